@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runArchive writes the objects under a prefix into an archive, which is the reverse of extract.
+func runArchive(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip archive <src> <dest>:\n")
+		fs.PrintDefaults()
+	}
+	logs := defineLogFlags(fs)
+	n := fs.Int("n", 16, "number of small objects read ahead")
+	chunkSize := cliflag.Bytes(fs, "chunk", 16*1024*1024, "upload chunk size of the archive")
+	tmpDir := fs.String("tmp-dir", "", "temporary directory of the objects with Content-Encoding in tar archives")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the objects and the archive")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	if err := logs.setup(); err != nil {
+		return err
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = gcsunzip.Archive(context.Background(), gcsunzip.ArchiveConfig{
+		Src:           fs.Arg(0),
+		Dest:          fs.Arg(1),
+		Concurrency:   *n,
+		ChunkSize:     int(*chunkSize),
+		TmpDir:        *tmpDir,
+		EncryptionKey: csek,
+	})
+	return err
+}
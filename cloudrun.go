@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// cloudRunTask returns the index and the count of the task in a Cloud Run Jobs execution,
+// which are 0 and 1 outside of it.
+func cloudRunTask() (index, count int, err error) {
+	count = 1
+	if s := os.Getenv("CLOUD_RUN_TASK_COUNT"); s != "" {
+		if count, err = strconv.Atoi(s); err != nil {
+			return 0, 0, fmt.Errorf("CLOUD_RUN_TASK_COUNT: %w", err)
+		}
+	}
+	if s := os.Getenv("CLOUD_RUN_TASK_INDEX"); s != "" {
+		if index, err = strconv.Atoi(s); err != nil {
+			return 0, 0, fmt.Errorf("CLOUD_RUN_TASK_INDEX: %w", err)
+		}
+	}
+	return index, count, nil
+}
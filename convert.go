@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runConvert re-packages an archive into another format without extracting it into objects.
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip convert <src> <dest>:\n")
+		fs.PrintDefaults()
+	}
+	logs := defineLogFlags(fs)
+	chunkSize := cliflag.Bytes(fs, "chunk", 16*1024*1024, "upload chunk size of the converted archive")
+	tmpDir := fs.String("tmp-dir", "", "temporary directory of the entries whose sizes are unknown in tar archives")
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the source and the converted archive")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	if err := logs.setup(); err != nil {
+		return err
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = gcsunzip.Convert(context.Background(), gcsunzip.ConvertConfig{
+		Src:           fs.Arg(0),
+		Dest:          fs.Arg(1),
+		ChunkSize:     int(*chunkSize),
+		TmpDir:        *tmpDir,
+		Encoding:      *encodingName,
+		OldWindows:    *oldWindows,
+		EncryptionKey: csek,
+	})
+	return err
+}
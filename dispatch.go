@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/cloudtasks/v2"
+	"google.golang.org/api/googleapi"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runDispatch enqueues a Cloud Task per range of entries of an archive instead of extracting it.
+// Each task requests POST /extract of serve with a shard of the archive.
+func runDispatch(args []string) error {
+	fs := flag.NewFlagSet("dispatch", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip dispatch <src> <dest>:\n")
+		fs.PrintDefaults()
+	}
+	queue := fs.String("queue", "", "Cloud Tasks queue (projects/P/locations/L/queues/Q)")
+	url := fs.String("url", "", "URL of the extraction endpoint of serve (e.g. https://gcs-unzip-xxx.a.run.app/extract)")
+	perTask := fs.Int("entries-per-task", 10000, "number of entries extracted by each task")
+	serviceAccount := fs.String("service-account", "", "service account email of the OIDC token of the tasks (e.g. for Cloud Run with authentication)")
+	dispatchDeadline := fs.Duration("dispatch-deadline", 0, "deadline of each task request, up to 30m (0 means the queue's default)")
+	n := fs.Int("n", 16, "number of goroutines for creating tasks")
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the source")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	if *queue == "" || *url == "" {
+		return fmt.Errorf("%w: -queue and -url are required", gcsunzip.ErrUsage)
+	}
+	if *perTask <= 0 {
+		return fmt.Errorf("%w: invalid -entries-per-task: %d", gcsunzip.ErrUsage, *perTask)
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+	src, dest := fs.Arg(0), fs.Arg(1)
+
+	ctx := context.Background()
+	entries, err := gcsunzip.List(ctx, gcsunzip.ListConfig{
+		Src:           src,
+		Encoding:      *encodingName,
+		OldWindows:    *oldWindows,
+		EncryptionKey: csek,
+	})
+	if err != nil {
+		return err
+	}
+	files := 0
+	for _, e := range entries {
+		if !e.Dir {
+			files++
+		}
+	}
+	count := max((files+*perTask-1) / *perTask, 1)
+
+	svc, err := cloudtasks.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("cloudtasks: %w", err)
+	}
+	// task names are derived from the job so that dispatching it again does not enqueue duplicates.
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", src, dest, count)))
+	prefix := hex.EncodeToString(h[:8])
+	var deadline string
+	if *dispatchDeadline > 0 {
+		deadline = fmt.Sprintf("%ds", int64(*dispatchDeadline/time.Second))
+	}
+
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(*n)
+	for i := 0; i < count; i++ {
+		eg.Go(func() error {
+			body, err := json.Marshal(extractRequest{Src: src, Dest: dest, ShardIndex: i, ShardCount: count})
+			if err != nil {
+				return err
+			}
+			task := &cloudtasks.Task{
+				Name:             fmt.Sprintf("%s/tasks/%s-%d", *queue, prefix, i),
+				DispatchDeadline: deadline,
+				HttpRequest: &cloudtasks.HttpRequest{
+					HttpMethod: "POST",
+					Url:        *url,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       base64.StdEncoding.EncodeToString(body),
+				},
+			}
+			if *serviceAccount != "" {
+				task.HttpRequest.OidcToken = &cloudtasks.OidcToken{ServiceAccountEmail: *serviceAccount}
+			}
+			_, err = svc.Projects.Locations.Queues.Tasks.Create(*queue, &cloudtasks.CreateTaskRequest{Task: task}).Context(gctx).Do()
+			var gerr *googleapi.Error
+			if errors.As(err, &gerr) && gerr.Code == http.StatusConflict {
+				slog.Debug(fmt.Sprintf("task %d/%d already exists", i, count))
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("create task %d/%d: %w", i, count, err)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	slog.LogAttrs(ctx, slog.LevelInfo, fmt.Sprintf("dispatched %d entries of %s as %d tasks", files, src, count),
+		slog.String("event", "dispatch"),
+		slog.String("src", src),
+		slog.Int("files", files),
+		slog.Int("tasks", count),
+	)
+	return nil
+}
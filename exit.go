@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// Exit codes. They are stable so that orchestrators can branch on them.
+const (
+	exitFailure           = 1
+	exitUsage             = 2
+	exitPartialFailure    = 3
+	exitDeadlineExceeded  = 4
+	exitInterrupted       = 5
+	exitSourceNotFound    = 6
+	exitUnsupportedFormat = 7
+	exitNoSpace           = 8
+	exitUpload            = 9
+	exitVerify            = 10
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, gcsunzip.ErrUsage):
+		return exitUsage
+	case errors.Is(err, gcsunzip.ErrPartialFailure):
+		return exitPartialFailure
+	case errors.Is(err, gcsunzip.ErrDeadlineExceeded):
+		return exitDeadlineExceeded
+	case errors.Is(err, gcsunzip.ErrInterrupted):
+		return exitInterrupted
+	case errors.Is(err, gcsunzip.ErrSourceNotFound):
+		return exitSourceNotFound
+	case errors.Is(err, gcsunzip.ErrUnsupportedFormat):
+		return exitUnsupportedFormat
+	case errors.Is(err, gcsunzip.ErrNoSpace), errors.Is(err, syscall.ENOSPC):
+		return exitNoSpace
+	case errors.Is(err, gcsunzip.ErrVerify):
+		return exitVerify
+	case errors.Is(err, gcsunzip.ErrUpload):
+		return exitUpload
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/orisano/gcs-unzip/pkg/jobpb"
+)
+
+// jobControlServer implements the JobControl service of pkg/jobpb on jobs.
+type jobControlServer struct {
+	jobpb.UnimplementedJobControlServer
+	jobs *jobManager
+}
+
+func (s *jobControlServer) Submit(_ context.Context, req *jobpb.SubmitRequest) (*jobpb.Job, error) {
+	if s.jobs.draining() {
+		return nil, status.Error(codes.Unavailable, "draining")
+	}
+	if req.GetSrc() == "" || req.GetDest() == "" {
+		return nil, status.Error(codes.InvalidArgument, "src and dest are required")
+	}
+	st := s.jobs.submit(extractRequest{
+		Src:        req.GetSrc(),
+		Dest:       req.GetDest(),
+		ShardIndex: int(req.GetShardIndex()),
+		ShardCount: int(req.GetShardCount()),
+	})
+	return jobProto(st), nil
+}
+
+func (s *jobControlServer) GetStatus(ctx context.Context, req *jobpb.GetStatusRequest) (*jobpb.Job, error) {
+	st, err := s.jobs.status(ctx, req.GetId())
+	if errors.Is(err, errJobNotFound) {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return jobProto(st), nil
+}
+
+func (s *jobControlServer) Cancel(ctx context.Context, req *jobpb.CancelRequest) (*jobpb.Job, error) {
+	st, ok := s.jobs.cancelJob(ctx, req.GetId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.GetId())
+	}
+	return jobProto(st), nil
+}
+
+func (s *jobControlServer) StreamProgress(req *jobpb.StreamProgressRequest, stream jobpb.JobControl_StreamProgressServer) error {
+	j := s.jobs.get(req.GetId())
+	if j == nil {
+		// a job which is not running here can only be sent once.
+		st, err := s.GetStatus(stream.Context(), &jobpb.GetStatusRequest{Id: req.GetId()})
+		if err != nil {
+			return err
+		}
+		return stream.Send(st)
+	}
+	for {
+		st, changed := j.snapshot()
+		if err := stream.Send(jobProto(st)); err != nil {
+			return err
+		}
+		if st.finished() {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+var jobStates = map[string]jobpb.State{
+	jobPending:  jobpb.State_STATE_PENDING,
+	jobRunning:  jobpb.State_STATE_RUNNING,
+	jobDone:     jobpb.State_STATE_DONE,
+	jobFailed:   jobpb.State_STATE_FAILED,
+	jobCanceled: jobpb.State_STATE_CANCELED,
+}
+
+func jobProto(s jobStatus) *jobpb.Job {
+	timestamp := func(t *time.Time) *timestamppb.Timestamp {
+		if t == nil {
+			return nil
+		}
+		return timestamppb.New(*t)
+	}
+	return &jobpb.Job{
+		Id:             s.ID,
+		Src:            s.Src,
+		Dest:           s.Dest,
+		ShardIndex:     int32(s.ShardIndex),
+		ShardCount:     int32(s.ShardCount),
+		State:          jobStates[s.State],
+		FilesDone:      s.FilesDone,
+		BytesDone:      s.BytesDone,
+		FilesTotal:     int64(s.FilesTotal),
+		BytesTotal:     int64(s.BytesTotal),
+		Failed:         int32(s.Failed),
+		Error:          s.Error,
+		CreateTime:     timestamppb.New(s.CreateTime),
+		StartTime:      timestamp(s.StartTime),
+		EndTime:        timestamp(s.EndTime),
+		Percent:        s.Percent,
+		BytesRemaining: s.BytesRemaining,
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runIndex writes the central directory of a zip archive on GCS as a JSON index, next to the archive by default.
+func runIndex(args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip index <src> [<dest>]:\n")
+		fs.PrintDefaults()
+	}
+	logs := defineLogFlags(fs)
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the source")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	if err := logs.setup(); err != nil {
+		return err
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = gcsunzip.WriteIndex(context.Background(), gcsunzip.IndexConfig{
+		Src:           fs.Arg(0),
+		Dest:          fs.Arg(1),
+		Encoding:      *encodingName,
+		OldWindows:    *oldWindows,
+		EncryptionKey: csek,
+	})
+	return err
+}
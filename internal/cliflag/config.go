@@ -0,0 +1,108 @@
+package cliflag
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is the prefix of environment variables for flags. e.g. GCS_UNZIP_GZIP_EXT for -gzip-ext.
+const envPrefix = "GCS_UNZIP_"
+
+// envName returns the environment variable name of the flag.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadEnv sets flags which are not given on the command line from the environment variables.
+func loadEnv(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("%s: %w", envName(f.Name), e)
+		}
+	})
+	return err
+}
+
+// loadConfig sets flags which are not given on the command line or the environment from the YAML file.
+// Keys are flag names. Lists set repeatable flags once per element, and are joined with commas for the others.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	var m map[string]any
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("parse: %w", err)
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for k, v := range m {
+		if fs.Lookup(k) == nil {
+			return fmt.Errorf("unknown flag: %s", k)
+		}
+		if set[k] {
+			continue
+		}
+		if xs, ok := v.([]any); ok {
+			if _, ok := fs.Lookup(k).Value.(repeatableFlag); ok {
+				for _, x := range xs {
+					s, err := configString(x)
+					if err != nil {
+						return fmt.Errorf("%s: %w", k, err)
+					}
+					if err := fs.Set(k, s); err != nil {
+						return fmt.Errorf("%s: %w", k, err)
+					}
+				}
+				continue
+			}
+		}
+		s, err := configString(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+		if err := fs.Set(k, s); err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func configString(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		xs := make([]string, 0, len(v))
+		for _, x := range v {
+			s, err := configString(x)
+			if err != nil {
+				return "", err
+			}
+			xs = append(xs, s)
+		}
+		return strings.Join(xs, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("unsupported value: %v", v)
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,258 @@
+// Package cliflag binds the options of the pipeline to flags, which are shared by the subcommands
+// and the other entrypoints such as Cloud Functions.
+package cliflag
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// Load sets flags which are not given on the command line from the environment and the config file
+// of the -config flag, which can also be given by the environment as GCS_UNZIP_CONFIG.
+func Load(fs *flag.FlagSet) error {
+	if err := loadEnv(fs); err != nil {
+		return fmt.Errorf("%w: env: %w", gcsunzip.ErrUsage, err)
+	}
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		if err := loadConfig(fs, f.Value.String()); err != nil {
+			return fmt.Errorf("%w: config: %w", gcsunzip.ErrUsage, err)
+		}
+	}
+	return nil
+}
+
+// DefineExtract defines the flags of the pipeline on fs.
+// The returned function builds a Config without Src and Dest from them after fs is parsed.
+func DefineExtract(fs *flag.FlagSet) func() (gcsunzip.Config, error) {
+	n := fs.Int("n", 24, "number of goroutines for uploading")
+	bufSize := Bytes(fs, "buf", 512*1024, "copy buffer size")
+	chunkSize := Bytes(fs, "chunk", 16*1024*1024, "upload chunk size")
+	layout := fs.String("layout", "path", "naming of objects: path (by the entry names) or cas (by the SHA-256 of the contents under <dest>/objects/ with <dest>/manifests/<archive>.jsonl)")
+	dedupe := fs.Bool("dedupe", false, "upload identical entries (by CRC-32 and sizes) once and copy the object to the others on the server side")
+	splitSize := Bytes(fs, "split-size", 0, "upload files larger than this as <name>.part-NNNN objects of this size with <name>.parts.json listing them (0 means disabled)")
+	gcInterval := fs.Int("gc", 0, "gc interval")
+	diskLimit := Bytes(fs, "disk-limit", 50*1024*1024*1024, "disk limit")
+	tmpDir := fs.String("tmp-dir", "", "temporary directory")
+	gzipExt := fs.String("gzip-ext", "", "comma-separated list of file extensions to gzip before uploading")
+	gzipTypes := fs.String("gzip-types", "", "comma-separated list of sniffed MIME types to gzip before uploading (e.g. text/*,application/json)")
+	gzipMinSize := Bytes(fs, "gzip-min-size", 0, "do not gzip files smaller than this")
+	compress := fs.String("compress", "gzip", "Content-Encoding of files selected by -gzip-ext and -gzip-types: gzip, zstd or br")
+	rawEncoding := fs.Bool("precompressed-encoding", false, "upload gzip or zstd files as is with their Content-Encoding instead of skipping the compression")
+	zstdExt := fs.String("zstd-ext", "", "comma-separated list of file extensions to compress with zstd before uploading")
+	withMeta := fs.Bool("with-meta", false, "")
+	skipTop := flagSkipTop(fs, "skip-top", "strip the top-level directory named after the archive, or any single top-level directory with -skip-top=auto")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	verify := fs.Bool("verify", false, "verify uploaded objects against the archive after uploading")
+	dryRun := fs.Bool("dry-run", false, "read the archive with range requests and log the estimated cost of the uploads without extracting it")
+	ifExists := fs.String("if-exists", "overwrite", "behavior when a destination object exists: skip, overwrite or fail")
+	sync := fs.Bool("sync", false, "upload only the entries which are new or changed from the destination objects (implies -if-exists skip)")
+	syncBy := fs.String("sync-by", "checksum", "how -sync compares an entry with its object: checksum (size and CRC32C), mtime or crc32 (of the entry recorded on upload), the last two without extracting unchanged entries")
+	deleteExtraneous := fs.Bool("delete-extraneous", false, "delete the objects under the destination prefix which are not in the archive after all uploads succeed (requires -sync)")
+	ifGenerationMatch := fs.String("if-generation-match", "", "upload only if the destination object has this generation (0 means the object must not exist, and others require a single file)")
+	successMarker := fs.String("success-marker", "", "name of the object written under the destination after all uploads succeed (e.g. _SUCCESS)")
+	preserveArchive := fs.String("preserve-archive", "", "copy the source archive on the server side to this gs:// object, or prefix ending with /, after all uploads succeed (e.g. gs://bucket/dest/_source/)")
+	bqManifest := fs.String("bq-manifest", "", "BigQuery table ([project.]dataset.table) to stream a row per uploaded object into")
+	manifestPath := fs.String("manifest", "", "local file or gs:// object to write a JSON Lines manifest of uploaded objects")
+	continueOnError := fs.Bool("continue-on-error", false, "continue with the remaining entries when an entry fails")
+	errorReport := fs.String("error-report", "", "local file or gs:// object to write a JSON Lines report of failed entries")
+	retryMaxAttempts := fs.Int("retry-max-attempts", 0, "maximum number of attempts for GCS requests (0 means unlimited)")
+	retryInitialBackoff := fs.Duration("retry-initial-backoff", 0, "initial backoff of GCS retries (0 means 1s)")
+	retryMaxBackoff := fs.Duration("retry-max-backoff", 0, "maximum backoff of GCS retries (0 means 30s)")
+	retryTimeout := fs.Duration("retry-timeout", 0, "deadline for each GCS request with its retries: upload chunks, reads of the archive, metadata requests and copies (0 means 32s for upload chunks and no deadline for the others)")
+	fileTimeout := fs.Duration("file-timeout", 0, "timeout for uploading each file, retried up to 3 times on expiry (0 means no timeout)")
+	maxTotalSize := Bytes(fs, "max-total-size", 1024*1024*1024*1024, "maximum total uncompressed size of the archive (0 means unlimited)")
+	maxRatio := fs.Float64("max-ratio", 0, "maximum compression ratio of an entry (0 means unlimited)")
+	onBomb := fs.String("on-bomb", "abort", "behavior for entries exceeding -max-ratio or their declared size: abort or skip (counted as skipped, not failed)")
+	maxFiles := fs.Int("max-files", 10000000, "maximum number of entries in the archive (0 means unlimited)")
+	duplicates := fs.String("duplicates", "last-wins", "policy for entries with the same name: last-wins, first-wins, suffix or fail")
+	sanitize := fs.String("sanitize", "none", "policy for characters which are invalid in object names: none, replace or strip, listing the renamed entries as renamed of -summary-json")
+	normalize := fs.String("normalize", "none", "unicode normalization form of object names: nfc, nfd or none")
+	symlinks := fs.String("symlinks", "skip", "policy for symlink entries: skip, materialize (upload the target file) or metadata (empty object with the target in metadata)")
+	keepEmptyDirs := fs.Bool("keep-empty-dirs", false, "upload a zero-byte \"dir/\" object for each empty directory entry")
+	strictNames := fs.Bool("strict-names", false, "fail if an entry name is neither valid UTF-8 nor decodable with the fallback encoding")
+	unsafePaths := fs.String("unsafe-paths", "rebase", "policy for absolute, drive-letter or parent-relative entry names: reject or rebase (under the archive root)")
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	undecodable := fs.String("undecodable", "replace", "policy for names which cannot be decoded: hex (percent-encode bytes), replace (with U+FFFD) or skip")
+	include := flagStrings(fs, "include", "glob of entry names to extract, repeatable or comma-separated (e.g. **/*.parquet)")
+	exclude := flagStrings(fs, "exclude", "glob of entry names to skip after -include, repeatable or comma-separated (e.g. **/tmp/**)")
+	only := flagStrings(fs, "only", "glob of entry names to extract with range requests instead of downloading the archive, repeatable or comma-separated (e.g. data/2024/report.csv)")
+	includeRe := flagRegexps(fs, "include-re", "RE2 pattern of entry names to extract, repeatable")
+	excludeRe := flagRegexps(fs, "exclude-re", "RE2 pattern of entry names to skip after the includes, repeatable")
+	minSize := Bytes(fs, "min-size", 0, "skip entries smaller than this")
+	maxSize := Bytes(fs, "max-size", 0, "skip entries larger than this (0 means unlimited)")
+	strip := fs.Int("strip-components", 0, "drop the first N path components of entry names, skipping entries which have no more")
+	flatten := fs.Bool("flatten", false, "upload files directly under the archive root by their base names")
+	flattenCollisions := fs.String("flatten-collisions", "fail", "policy for files with the same base name in -flatten: fail, suffix or hash")
+	renames := flagRenames(fs, "rename", "sed-like rule to rewrite entry names, repeatable (e.g. s#^data/raw/#bronze/#)")
+	noArchivePrefix := fs.Bool("no-archive-prefix", false, "upload entries directly under the destination prefix instead of <prefix>/<archive>/")
+	filterCommand := fs.String("filter-cmd", "", "shell command run for each file before uploading, which prints allow, skip or fail (e.g. './policy.sh {src}')")
+	transformCmd := fs.String("transform-cmd", "", "shell command whose stdout replaces each file before uploading, reading {src} or stdin (e.g. 'cut -d, -f1,3 {src}')")
+	transformInclude := flagStrings(fs, "transform-include", "glob of entry names to run -transform-cmd on, repeatable or comma-separated (default all files)")
+	contentTypes := flagContentTypes(fs, "content-type-map", "comma-separated list of ext=type, or a TSV file of them, to set Content-Type")
+	cacheControl := fs.String("cache-control", "", "Cache-Control of uploaded objects (e.g. public, max-age=31536000, immutable)")
+	cacheControlExt := flagExtValues(fs, "cache-control-ext", "override of -cache-control for an extension as ext=value, repeatable (e.g. html=no-cache)")
+	contentDisposition := fs.String("content-disposition", "", "Content-Disposition of uploaded objects, where {name} is the base name (e.g. attachment; filename=\"{name}\")")
+	contentDispositionExt := flagExtValues(fs, "content-disposition-ext", "override of -content-disposition for an extension as ext=value, repeatable")
+	contentLanguage := fs.String("content-language", "", "Content-Language of uploaded objects (e.g. ja)")
+	contentLanguageExt := flagExtValues(fs, "content-language-ext", "override of -content-language for an extension as ext=value, repeatable")
+	gcsMeta := fs.String("gcs-meta", "", "comma-separated list of key=value to set as custom metadata of uploaded objects")
+	gcsMetaFile := fs.String("gcs-meta-file", "", "JSON file of an object of custom metadata of uploaded objects, overridden by -gcs-meta")
+	attrsRulesPath := fs.String("attrs-rules", "", "YAML file of rules which set object attributes for entries matching globs")
+	storageClass := fs.String("storage-class", "", "storage class of uploaded objects: STANDARD, NEARLINE, COLDLINE or ARCHIVE (default the bucket's)")
+	kmsKey := fs.String("kms-key", "", "Cloud KMS key to encrypt uploaded objects (projects/P/locations/L/keyRings/R/cryptoKeys/K)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key to read the source and write uploaded objects")
+	customTimeFlag := fs.String("custom-time", "", "CustomTime of uploaded objects: now, source-mtime (of the archive) or an RFC 3339 time")
+	runReport := fs.String("report", "", "local file or gs:// object to write the summary of the job as JSON when it finishes, which can contain {archive}, {date} and {ts}")
+	notifyTopic := fs.String("notify-topic", "", "Pub/Sub topic to publish a JSON summary when the job succeeds or fails (projects/P/topics/T)")
+	notifyURL := fs.String("notify-url", "", "URL to POST a JSON summary when the job succeeds or fails, with retries")
+	notifySecret := fs.String("notify-secret", "", "secret to sign the requests of -notify-url by HMAC-SHA256 in X-Gcs-Unzip-Signature")
+	heartbeat := fs.Duration("heartbeat", 0, "log a line of the progress and the rate at this interval even without -v, to detect stalled jobs (0 means disabled)")
+	progressInterval := fs.Duration("progress-interval", 5*time.Second, "interval of -progress-json")
+	checkpointPath := fs.String("checkpoint", "", "local file or gs:// object to record uploaded entries for resuming the same generation of the archive")
+	checkpointInterval := fs.Duration("checkpoint-interval", 30*time.Second, "checkpoint save interval")
+
+	return func() (gcsunzip.Config, error) {
+		csek, err := DecodeKey(*encryptionKey)
+		if err != nil {
+			return gcsunzip.Config{}, err
+		}
+
+		metadata := map[string]string{}
+		if *gcsMetaFile != "" {
+			b, err := os.ReadFile(*gcsMetaFile)
+			if err != nil {
+				return gcsunzip.Config{}, fmt.Errorf("%w: -gcs-meta-file: %w", gcsunzip.ErrUsage, err)
+			}
+			if err := json.Unmarshal(b, &metadata); err != nil {
+				return gcsunzip.Config{}, fmt.Errorf("%w: -gcs-meta-file: parse: %w", gcsunzip.ErrUsage, err)
+			}
+		}
+		if *gcsMeta != "" {
+			for _, kv := range strings.Split(*gcsMeta, ",") {
+				k, v, ok := strings.Cut(kv, "=")
+				if !ok {
+					return gcsunzip.Config{}, fmt.Errorf("%w: invalid -gcs-meta: %s", gcsunzip.ErrUsage, kv)
+				}
+				metadata[k] = v
+			}
+		}
+
+		var attrsRules []gcsunzip.AttrsRule
+		if *attrsRulesPath != "" {
+			attrsRules, err = gcsunzip.LoadAttrsRules(*attrsRulesPath)
+			if err != nil {
+				return gcsunzip.Config{}, fmt.Errorf("%w: -attrs-rules: %w", gcsunzip.ErrUsage, err)
+			}
+		}
+
+		var filter func(context.Context, gcsunzip.FilterEntry) (gcsunzip.FilterDecision, error)
+		if *filterCommand != "" {
+			filter = filterCmd(*filterCommand)
+		}
+
+		var generation *int64
+		if *ifGenerationMatch != "" {
+			g, err := strconv.ParseInt(*ifGenerationMatch, 10, 64)
+			if err != nil {
+				return gcsunzip.Config{}, fmt.Errorf("%w: parse -if-generation-match: %w", gcsunzip.ErrUsage, err)
+			}
+			generation = &g
+		}
+
+		return gcsunzip.Config{
+			Concurrency:           *n,
+			BufSize:               *bufSize,
+			ChunkSize:             *chunkSize,
+			Dedupe:                *dedupe,
+			SplitSize:             *splitSize,
+			Layout:                *layout,
+			GCInterval:            *gcInterval,
+			DiskLimit:             *diskLimit,
+			TmpDir:                *tmpDir,
+			GzipExt:               SplitList(*gzipExt),
+			GzipTypes:             SplitList(*gzipTypes),
+			GzipMinSize:           *gzipMinSize,
+			Compress:              *compress,
+			PrecompressedEncoding: *rawEncoding,
+			ZstdExt:               SplitList(*zstdExt),
+			WithMeta:              *withMeta,
+			SkipTop:               *skipTop,
+			OldWindows:            *oldWindows,
+			Verify:                *verify,
+			DryRun:                *dryRun,
+			IfExists:              *ifExists,
+			Sync:                  *sync,
+			SyncBy:                *syncBy,
+			DeleteExtraneous:      *deleteExtraneous,
+			IfGenerationMatch:     generation,
+			SuccessMarker:         *successMarker,
+			PreserveArchive:       *preserveArchive,
+			Manifest:              *manifestPath,
+			BQManifest:            *bqManifest,
+			ContinueOnError:       *continueOnError,
+			ErrorReport:           *errorReport,
+			RetryMaxAttempts:      *retryMaxAttempts,
+			RetryInitialBackoff:   *retryInitialBackoff,
+			RetryMaxBackoff:       *retryMaxBackoff,
+			RetryTimeout:          *retryTimeout,
+			FileTimeout:           *fileTimeout,
+			MaxTotalSize:          *maxTotalSize,
+			MaxRatio:              *maxRatio,
+			OnBomb:                *onBomb,
+			MaxFiles:              *maxFiles,
+			Duplicates:            *duplicates,
+			Sanitize:              *sanitize,
+			Normalize:             *normalize,
+			Symlinks:              *symlinks,
+			KeepEmptyDirs:         *keepEmptyDirs,
+			StrictNames:           *strictNames,
+			UnsafePaths:           *unsafePaths,
+			Encoding:              *encodingName,
+			Undecodable:           *undecodable,
+			Include:               *include,
+			Only:                  *only,
+			Exclude:               *exclude,
+			IncludeRe:             *includeRe,
+			ExcludeRe:             *excludeRe,
+			MinSize:               *minSize,
+			MaxSize:               *maxSize,
+			StripComponents:       *strip,
+			Flatten:               *flatten,
+			FlattenCollisions:     *flattenCollisions,
+			Renames:               *renames,
+			NoArchivePrefix:       *noArchivePrefix,
+			Filter:                filter,
+			TransformCmd:          *transformCmd,
+			TransformInclude:      *transformInclude,
+			ContentTypes:          *contentTypes,
+			CacheControl:          *cacheControl,
+			CacheControlExt:       *cacheControlExt,
+			ContentDisposition:    *contentDisposition,
+			ContentDispositionExt: *contentDispositionExt,
+			ContentLanguage:       *contentLanguage,
+			ContentLanguageExt:    *contentLanguageExt,
+			Metadata:              metadata,
+			AttrsRules:            attrsRules,
+			StorageClass:          *storageClass,
+			KMSKey:                *kmsKey,
+			EncryptionKey:         csek,
+			CustomTime:            *customTimeFlag,
+			RunReport:             *runReport,
+			NotifyTopic:           *notifyTopic,
+			NotifyURL:             *notifyURL,
+			NotifySecret:          *notifySecret,
+			ProgressInterval:      *progressInterval,
+			HeartbeatInterval:     *heartbeat,
+			Checkpoint:            *checkpointPath,
+			CheckpointInterval:    *checkpointInterval,
+		}, nil
+	}
+}
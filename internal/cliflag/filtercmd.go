@@ -0,0 +1,48 @@
+package cliflag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// filterCmd returns a filter which runs the shell command cmdline for each file, whose stdout is allow (or empty),
+// skip or fail. A non-zero exit status fails the file. {src} is replaced by the quoted path of the file, and
+// the entry is also passed as GCS_UNZIP_ENTRY, GCS_UNZIP_SIZE, GCS_UNZIP_CONTENT_TYPE and GCS_UNZIP_PATH.
+func filterCmd(cmdline string) func(context.Context, gcsunzip.FilterEntry) (gcsunzip.FilterDecision, error) {
+	return func(ctx context.Context, e gcsunzip.FilterEntry) (gcsunzip.FilterDecision, error) {
+		quoted := "'" + strings.ReplaceAll(e.Path, "'", `'\''`) + "'"
+		cmd := exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(cmdline, "{src}", quoted))
+		cmd.Env = append(os.Environ(),
+			"GCS_UNZIP_ENTRY="+e.Name,
+			"GCS_UNZIP_SIZE="+strconv.FormatInt(e.Size, 10),
+			"GCS_UNZIP_CONTENT_TYPE="+e.ContentType,
+			"GCS_UNZIP_PATH="+e.Path,
+		)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return gcsunzip.FilterFail, fmt.Errorf("filter-cmd: %w: %s", err, msg)
+			}
+			return gcsunzip.FilterFail, fmt.Errorf("filter-cmd: %w", err)
+		}
+		switch d := strings.TrimSpace(string(out)); d {
+		case "", "allow":
+			return gcsunzip.FilterAllow, nil
+		case "skip":
+			return gcsunzip.FilterSkip, nil
+		case "fail":
+			return gcsunzip.FilterFail, nil
+		default:
+			return gcsunzip.FilterFail, fmt.Errorf("filter-cmd: unknown decision: %s", d)
+		}
+	}
+}
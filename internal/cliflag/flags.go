@@ -0,0 +1,256 @@
+package cliflag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// repeatableFlag is implemented by flags which accumulate values when they are set multiple times.
+type repeatableFlag interface {
+	flag.Value
+	IsRepeatable() bool
+}
+
+// Bytes defines a flag of a size such as 512k or 16m.
+func Bytes(fs *flag.FlagSet, name string, value uint64, usage string) *uint64 {
+	p := new(uint64)
+	*p = value
+	fs.Var((*bytesValue)(p), name, usage)
+	return p
+}
+
+type bytesValue uint64
+
+func (b *bytesValue) String() string {
+	return gcsunzip.FormatBytes(uint64(*b))
+}
+
+func (b *bytesValue) Set(s string) error {
+	v, err := gcsunzip.ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = bytesValue(v)
+	return nil
+}
+
+func flagStrings(fs *flag.FlagSet, name string, usage string) *[]string {
+	p := new([]string)
+	fs.Var((*stringsValue)(p), name, usage)
+	return p
+}
+
+// stringsValue is a repeatable flag of comma-separated values.
+type stringsValue []string
+
+func (s *stringsValue) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsValue) IsRepeatable() bool { return true }
+
+func (s *stringsValue) Set(v string) error {
+	for _, x := range strings.Split(v, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			*s = append(*s, x)
+		}
+	}
+	return nil
+}
+
+// SplitList splits a comma-separated flag value, which is nil if empty.
+func SplitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func flagRegexps(fs *flag.FlagSet, name string, usage string) *[]*regexp.Regexp {
+	p := new([]*regexp.Regexp)
+	fs.Var((*regexpsValue)(p), name, usage)
+	return p
+}
+
+// regexpsValue is a repeatable flag of RE2 patterns. Unlike stringsValue, commas are not separators.
+type regexpsValue []*regexp.Regexp
+
+func (r *regexpsValue) String() string {
+	xs := make([]string, len(*r))
+	for i, re := range *r {
+		xs[i] = re.String()
+	}
+	return strings.Join(xs, " ")
+}
+
+func (r *regexpsValue) IsRepeatable() bool { return true }
+
+func (r *regexpsValue) Set(v string) error {
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return fmt.Errorf("compile(%s): %w", v, err)
+	}
+	*r = append(*r, re)
+	return nil
+}
+
+func flagRenames(fs *flag.FlagSet, name string, usage string) *[]gcsunzip.RenameRule {
+	p := new([]gcsunzip.RenameRule)
+	fs.Var((*renamesValue)(p), name, usage)
+	return p
+}
+
+// renamesValue is a repeatable flag of rename rules.
+type renamesValue []gcsunzip.RenameRule
+
+func (r *renamesValue) String() string {
+	xs := make([]string, len(*r))
+	for i, rule := range *r {
+		xs[i] = rule.String()
+	}
+	return strings.Join(xs, " ")
+}
+
+func (r *renamesValue) IsRepeatable() bool { return true }
+
+func (r *renamesValue) Set(v string) error {
+	rule, err := gcsunzip.ParseRenameRule(v)
+	if err != nil {
+		return fmt.Errorf("parse(%s): %w", v, err)
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+func flagContentTypes(fs *flag.FlagSet, name string, usage string) *map[string]string {
+	p := &map[string]string{}
+	fs.Var((*contentTypesValue)(p), name, usage)
+	return p
+}
+
+// contentTypesValue is a comma-separated list of ext=type, or a path of a TSV file whose lines are "ext\ttype".
+type contentTypesValue map[string]string
+
+func (c *contentTypesValue) String() string {
+	return extMapString(*c, ",")
+}
+
+func (c *contentTypesValue) IsRepeatable() bool { return true }
+
+func (c *contentTypesValue) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return c.load(v)
+	}
+	for _, kv := range strings.Split(v, ",") {
+		ext, typ, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("parse(%s): must be ext=type", kv)
+		}
+		(*c)[strings.TrimSpace(ext)] = strings.TrimSpace(typ)
+	}
+	return nil
+}
+
+func (c *contentTypesValue) load(name string) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ext, typ, ok := strings.Cut(line, "\t")
+		if !ok {
+			return fmt.Errorf("%s:%d: must be ext<TAB>type", name, n)
+		}
+		(*c)[strings.TrimSpace(ext)] = strings.TrimSpace(typ)
+	}
+	return sc.Err()
+}
+
+func extMapString(m map[string]string, sep string) string {
+	xs := make([]string, 0, len(m))
+	for k, v := range m {
+		xs = append(xs, k+"="+v)
+	}
+	sort.Strings(xs)
+	return strings.Join(xs, sep)
+}
+
+func flagExtValues(fs *flag.FlagSet, name string, usage string) *map[string]string {
+	p := &map[string]string{}
+	fs.Var((*extValuesValue)(p), name, usage)
+	return p
+}
+
+// extValuesValue is a repeatable flag of ext=value. Unlike contentTypesValue, commas are part of the value.
+type extValuesValue map[string]string
+
+func (e *extValuesValue) String() string {
+	return extMapString(*e, " ")
+}
+
+func (e *extValuesValue) IsRepeatable() bool { return true }
+
+func (e *extValuesValue) Set(v string) error {
+	ext, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("parse(%s): must be ext=value", v)
+	}
+	(*e)[strings.TrimSpace(ext)] = strings.TrimSpace(value)
+	return nil
+}
+
+func flagSkipTop(fs *flag.FlagSet, name string, usage string) *string {
+	p := new(string)
+	*p = "false"
+	fs.Var((*skipTopValue)(p), name, usage)
+	return p
+}
+
+// skipTopValue is a boolean flag which also accepts auto.
+type skipTopValue string
+
+func (s *skipTopValue) String() string {
+	return string(*s)
+}
+
+func (s *skipTopValue) IsBoolFlag() bool { return true }
+
+func (s *skipTopValue) Set(v string) error {
+	if v == "auto" {
+		*s = "auto"
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("parse(%s): must be a boolean or auto", v)
+	}
+	*s = skipTopValue(strconv.FormatBool(b))
+	return nil
+}
+
+// DecodeKey decodes -encryption-key-base64, which is nil if empty.
+func DecodeKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%w: invalid -encryption-key-base64: must be 32 bytes in base64", gcsunzip.ErrUsage)
+	}
+	return key, nil
+}
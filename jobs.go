@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// jobRetention is how long finished jobs are kept for GetStatus.
+const jobRetention = time.Hour
+
+const (
+	// jobHeartbeat is the interval to save the state of an unfinished job even if it does not change.
+	jobHeartbeat = time.Minute
+	// jobStaleAfter is how long a saved unfinished job is considered alive without a heartbeat.
+	jobStaleAfter = 3 * jobHeartbeat
+)
+
+const (
+	jobPending  = "pending"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+var (
+	// errJobCanceled is the cause of jobs canceled by requests.
+	errJobCanceled = errors.New("canceled")
+	errJobNotFound = errors.New("job not found")
+)
+
+// jobStatus is a snapshot of a job, which is the response of the job APIs.
+type jobStatus struct {
+	ID             string     `json:"id"`
+	Src            string     `json:"src"`
+	Dest           string     `json:"dest"`
+	ShardIndex     int        `json:"shard_index,omitempty"`
+	ShardCount     int        `json:"shard_count,omitempty"`
+	State          string     `json:"state"`
+	FilesDone      int64      `json:"files_done"`
+	BytesDone      int64      `json:"bytes_done"`
+	FilesTotal     int        `json:"files_total"`
+	BytesTotal     uint64     `json:"bytes_total"`
+	Percent        float64    `json:"percent"`
+	BytesRemaining uint64     `json:"bytes_remaining"`
+	Failed         int        `json:"failed"`
+	Error          string     `json:"error,omitempty"`
+	CreateTime     time.Time  `json:"create_time"`
+	StartTime      *time.Time `json:"start_time,omitempty"`
+	EndTime        *time.Time `json:"end_time,omitempty"`
+	// Instance is the ID of the process running the job, and HeartbeatTime is when it last saved the job.
+	Instance      string     `json:"instance,omitempty"`
+	HeartbeatTime *time.Time `json:"heartbeat_time,omitempty"`
+}
+
+func (s *jobStatus) finished() bool {
+	return s.State == jobDone || s.State == jobFailed || s.State == jobCanceled
+}
+
+// markStale reports an unfinished job as failed if its instance stopped saving it, e.g. it was terminated.
+func (s *jobStatus) markStale(now time.Time) {
+	if s.finished() || s.HeartbeatTime == nil || now.Sub(*s.HeartbeatTime) < jobStaleAfter {
+		return
+	}
+	s.State = jobFailed
+	s.Error = fmt.Sprintf("stale: instance %s stopped updating the job at %s", s.Instance, s.HeartbeatTime.Format(time.RFC3339))
+	s.EndTime = s.HeartbeatTime
+}
+
+type job struct {
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	status jobStatus
+	// changed is closed and replaced whenever status changes.
+	changed chan struct{}
+}
+
+// snapshot returns the current status and a channel which is closed when it changes.
+func (j *job) snapshot() (jobStatus, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status, j.changed
+}
+
+func (j *job) update(f func(*jobStatus)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f(&j.status)
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// jobManager runs jobs, where asynchronous ones run with up to maxJobs at once.
+// The statuses are also saved to store if not nil.
+type jobManager struct {
+	ctx   context.Context
+	base  gcsunzip.Config
+	store *jobStore
+	// instance is the random ID of this process, which is saved with the jobs.
+	instance string
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	// closed is set by drain, after which new jobs should be refused.
+	closed atomic.Bool
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobManager(ctx context.Context, base gcsunzip.Config, store *jobStore, maxJobs int) *jobManager {
+	return &jobManager{ctx: ctx, base: base, store: store, instance: newJobID(), sem: make(chan struct{}, max(maxJobs, 1)), jobs: map[string]*job{}}
+}
+
+// drain marks m as draining before shutdown.
+func (m *jobManager) drain() { m.closed.Store(true) }
+
+func (m *jobManager) draining() bool { return m.closed.Load() }
+
+// newJobID returns a random ID of a job.
+func newJobID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// add registers a pending job of req, which is canceled when parent is done.
+// A job with the same ID, e.g. a retry of a task, replaces the previous one.
+func (m *jobManager) add(parent context.Context, id string, req extractRequest) (*job, context.Context) {
+	ctx, cancel := context.WithCancelCause(parent)
+	j := &job{
+		cancel: cancel,
+		status: jobStatus{
+			ID:         id,
+			Src:        req.Src,
+			Dest:       req.Dest,
+			ShardIndex: req.ShardIndex,
+			ShardCount: req.ShardCount,
+			State:      jobPending,
+			CreateTime: time.Now(),
+			Instance:   m.instance,
+		},
+		changed: make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.jobs[id] = j
+	m.mu.Unlock()
+	if m.store != nil {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.persist(j)
+		}()
+	}
+	return j, ctx
+}
+
+// persist saves the status of j whenever it changes, and every jobHeartbeat, until j finishes.
+// Changes during a save are coalesced into the next one.
+func (m *jobManager) persist(j *job) {
+	t := time.NewTicker(jobHeartbeat)
+	defer t.Stop()
+	for {
+		st, changed := j.snapshot()
+		st.HeartbeatTime = ptr(time.Now())
+		// the final status is saved even after m.ctx is done.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(m.ctx), 30*time.Second)
+		err := m.store.save(ctx, st)
+		cancel()
+		if err != nil {
+			slog.Warn(fmt.Sprintf("failed to save the state of job %s: %v", st.ID, err))
+		}
+		if st.finished() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-t.C:
+		}
+	}
+}
+
+// submit starts a job of req asynchronously, which is pending until a slot is available.
+func (m *jobManager) submit(req extractRequest) jobStatus {
+	j, ctx := m.add(m.ctx, newJobID(), req)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		select {
+		case m.sem <- struct{}{}:
+			m.run(ctx, j, req)
+			<-m.sem
+		case <-ctx.Done():
+			m.finish(ctx, j, gcsunzip.Report{}, context.Cause(ctx))
+		}
+	}()
+	s, _ := j.snapshot()
+	return s
+}
+
+// extract runs a job of req with id synchronously.
+func (m *jobManager) extract(ctx context.Context, id string, req extractRequest) (gcsunzip.Report, error) {
+	j, ctx := m.add(ctx, id, req)
+	return m.run(ctx, j, req)
+}
+
+func (m *jobManager) run(ctx context.Context, j *job, req extractRequest) (gcsunzip.Report, error) {
+	j.update(func(s *jobStatus) {
+		s.State = jobRunning
+		s.StartTime = ptr(time.Now())
+	})
+	cfg := m.base
+	cfg.Src, cfg.Dest = req.Src, req.Dest
+	cfg.ShardIndex, cfg.ShardCount = req.ShardIndex, req.ShardCount
+	cfg.ProgressFunc = func(r gcsunzip.ProgressRecord) {
+		j.update(func(s *jobStatus) {
+			s.FilesDone, s.BytesDone = r.FilesDone, r.BytesDone
+			s.FilesTotal, s.BytesTotal = r.FilesTotal, r.BytesTotal
+			s.Percent, s.BytesRemaining = r.Percent, r.BytesRemaining
+		})
+	}
+	report, err := gcsunzip.Run(ctx, cfg)
+	m.finish(ctx, j, report, err)
+	return report, err
+}
+
+func (m *jobManager) finish(ctx context.Context, j *job, report gcsunzip.Report, err error) {
+	j.update(func(s *jobStatus) {
+		s.FilesDone, s.BytesDone, s.Failed = report.Files, report.Bytes, report.Failed
+		s.EndTime = ptr(time.Now())
+		switch {
+		case err != nil && errors.Is(context.Cause(ctx), errJobCanceled):
+			s.State = jobCanceled
+		case err != nil:
+			s.State = jobFailed
+		default:
+			s.State = jobDone
+		}
+		if err != nil {
+			s.Error = err.Error()
+		}
+	})
+	j.cancel(nil)
+	time.AfterFunc(jobRetention, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.jobs[j.status.ID] == j {
+			delete(m.jobs, j.status.ID)
+		}
+	})
+}
+
+// get returns the job of id, which is nil if not found.
+func (m *jobManager) get(id string) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[id]
+}
+
+// status returns the status of the job of id, which is loaded from the store if the job is not in memory,
+// e.g. it ran on another instance or before a restart. It returns errJobNotFound if not found.
+// A loaded job which is not finished is failed if its instance has not saved it for jobStaleAfter.
+func (m *jobManager) status(ctx context.Context, id string) (jobStatus, error) {
+	if j := m.get(id); j != nil {
+		st, _ := j.snapshot()
+		return st, nil
+	}
+	if m.store == nil {
+		return jobStatus{}, errJobNotFound
+	}
+	st, err := m.store.load(ctx, id)
+	if err != nil {
+		return st, err
+	}
+	st.markStale(time.Now())
+	return st, nil
+}
+
+// cancelJob cancels the job of id and waits for it to finish. It returns false if not found.
+func (m *jobManager) cancelJob(ctx context.Context, id string) (jobStatus, bool) {
+	j := m.get(id)
+	if j == nil {
+		return jobStatus{}, false
+	}
+	j.cancel(errJobCanceled)
+	for {
+		s, changed := j.snapshot()
+		if s.finished() {
+			return s, true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return s, true
+		}
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// wait waits for all jobs to finish or ctx to be done.
+func (m *jobManager) wait(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
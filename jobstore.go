@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// jobStore saves job statuses as JSON objects named <prefix><id>.json, so that they survive restarts
+// and can be read by other instances and services.
+type jobStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// newJobStore returns a store under a gs://bucket/prefix/ URL.
+func newJobStore(client *storage.Client, u string) (*jobStore, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(u, "gs://"), "/")
+	if !strings.HasPrefix(u, "gs://") || bucket == "" {
+		return nil, fmt.Errorf("must be gs://bucket/prefix/: %s", u)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &jobStore{bucket: client.Bucket(bucket), prefix: prefix}, nil
+}
+
+func (s *jobStore) object(id string) *storage.ObjectHandle {
+	return s.bucket.Object(s.prefix + path.Base(id) + ".json")
+}
+
+func (s *jobStore) save(ctx context.Context, st jobStatus) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	w := s.object(st.ID).NewWriter(ctx)
+	w.ContentType = "application/json"
+	w.CacheControl = "no-store"
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// load returns the saved status of id, or errJobNotFound.
+func (s *jobStore) load(ctx context.Context, id string) (jobStatus, error) {
+	var st jobStatus
+	r, err := s.object(id).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return st, errJobNotFound
+	}
+	if err != nil {
+		return st, err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&st); err != nil {
+		return st, fmt.Errorf("decode: %w", err)
+	}
+	return st, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runList prints the entries of an archive on GCS, reading only its directory with range requests.
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip list <src>:\n")
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print entries as JSON Lines")
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the source")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	entries, err := gcsunzip.List(context.Background(), gcsunzip.ListConfig{
+		Src:           fs.Arg(0),
+		Encoding:      *encodingName,
+		OldWindows:    *oldWindows,
+		EncryptionKey: csek,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	enc := json.NewEncoder(os.Stdout)
+	if !*asJSON {
+		fmt.Fprintln(w, "SIZE\tCOMPRESSED\tMETHOD\tMODIFIED\tNAME")
+	}
+	for _, e := range entries {
+		if *asJSON {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", e.Size, e.CompressedSize, e.Method, e.Modified.Format(time.DateTime), e.Name)
+	}
+	return w.Flush()
+}
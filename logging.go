@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// parseLogLevel parses error, warn, info or debug.
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	switch strings.ToLower(s) {
+	case "error":
+		l = slog.LevelError
+	case "warn", "warning":
+		l = slog.LevelWarn
+	case "info":
+		l = slog.LevelInfo
+	case "debug":
+		l = slog.LevelDebug
+	default:
+		return l, fmt.Errorf("unknown level: %s", s)
+	}
+	return l, nil
+}
+
+// textHandler writes "gcs-unzip: <time> <LEVEL> <message>" lines like the default logger.
+// The attributes of events are only emitted in JSON logs.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+}
+
+func newTextHandler(w io.Writer, level slog.Leveler) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.w, "gcs-unzip: %s %s %s\n", r.Time.Format("2006/01/02 15:04:05"), r.Level, r.Message)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *textHandler) WithGroup(string) slog.Handler      { return h }
@@ -2,467 +2,280 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"io/fs"
-	"log"
-	"net/http"
-	"net/url"
+	"log/slog"
 	"os"
-	"path"
-	"path/filepath"
-	"runtime"
-	"strconv"
-	"strings"
+	"os/signal"
 	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 
-	"cloud.google.com/go/storage"
-	"github.com/klauspost/compress/gzip"
 	"golang.org/x/sync/errgroup"
-	"golang.org/x/sync/semaphore"
-)
-
-const local = false
-
-func run() error {
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of gcs-unzip <src> <dest>:\n")
-		flag.PrintDefaults()
-	}
 
-	n := flag.Int("n", 24, "number of goroutines for uploading")
-	verbose := flag.Bool("v", false, "show verbose output")
-	bufSize := flagBytes("buf", 512*1024, "copy buffer size")
-	chunkSize := flagBytes("chunk", 16*1024*1024, "upload chunk size")
-	gcInterval := flag.Int("gc", 0, "gc interval")
-	diskLimit := flagBytes("disk-limit", 50*1024*1024*1024, "disk limit")
-	tmpDir := flag.String("tmp-dir", "", "temporary directory")
-	gzipExt := flag.String("gzip-ext", "", "comma-separated list of file extensions to gzip before uploading")
-	withMeta := flag.Bool("with-meta", false, "")
-	skipTop := flag.Bool("skip-top", false, "")
-	oldWindows := flag.Bool("old-windows", false, "")
-
-	flag.Parse()
-	if flag.NArg() != 2 {
-		flag.Usage()
-		return fmt.Errorf("invalid args")
-	}
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
 
-	src, err := parseGSURL(flag.Arg(0))
-	if err != nil {
-		return fmt.Errorf("parse src: %w", err)
+func runExtract(args []string) (err error) {
+	fs := flag.CommandLine
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip [extract] <src>... <dest>, or -shard-spec <spec>:\n")
+		fs.PrintDefaults()
+	}
+	logs := defineLogFlags(fs)
+	extractConfig := cliflag.DefineExtract(fs)
+	deadline := fs.Duration("deadline", 0, "cancel the job when it runs longer than this (0 means no deadline)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "time to wait for in-flight uploads after SIGINT or SIGTERM")
+	jobs := fs.Int("jobs", 1, "number of archives extracted at once when multiple sources are given")
+	memoryLimit := cliflag.Bytes(fs, "memory-limit", 0, "memory for the chunks of uploads shared by the archives extracted at once (0 means unlimited)")
+	otelEndpoint := fs.String("otel-endpoint", "", "OTLP/gRPC endpoint to export traces of the job to (e.g. http://localhost:4317)")
+	summaryJSON := fs.String("summary-json", "", "write the summary of the job with its statistics as JSON to this file (- means stdout)")
+	pprofAddr := fs.String("pprof-addr", "", "address to serve net/http/pprof on, e.g. localhost:6060 (disabled if empty)")
+	traceFile := fs.String("trace", "", "write a runtime execution trace to this file, for go tool trace")
+	shardSpec := fs.String("shard-spec", "", "extract the shard of this spec written by plan (a file or gs:// object, or shard-<CLOUD_RUN_TASK_INDEX>.json under it if it ends with /) instead of <src> <dest>")
+	progressJSON := fs.String("progress-json", "", "write progress as JSON Lines periodically to this file or named pipe (- means stdout)")
+	showVersion := fs.Bool("version", false, "print the version and exit")
+	fs.String("config", "", "YAML file of flags, which are overridden by the command line")
+
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if *showVersion {
+		printVersion(os.Stdout)
+		return nil
 	}
-
-	dest, err := parseGSURL(flag.Arg(1))
-	if err != nil {
-		return fmt.Errorf("parse dest: %w", err)
+	if *shardSpec == "" && fs.NArg() < 2 || *shardSpec != "" && fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
 	}
-
-	switch ext := path.Ext(src.Path); strings.ToLower(ext) {
-	case ".7z", ".zip":
-	default:
-		return fmt.Errorf("unsupported format: %s", ext)
+	if err := logs.setup(); err != nil {
+		return err
 	}
-
-	ctx := context.Background()
-	gcs, err := storage.NewClient(ctx)
+	cfg, err := extractConfig()
 	if err != nil {
-		return fmt.Errorf("storage client: %w", err)
+		return err
 	}
-
-	workDir, err := os.MkdirTemp(*tmpDir, "")
+	index, count, err := cloudRunTask()
 	if err != nil {
-		return fmt.Errorf("make work dir: %w", err)
+		return fmt.Errorf("%w: %w", gcsunzip.ErrUsage, err)
 	}
-	defer func() {
-		err := os.RemoveAll(workDir)
+	var srcs []string
+	if *shardSpec != "" {
+		cfg.Shard, err = gcsunzip.LoadShardSpec(context.Background(), nil, *shardSpec, index)
 		if err != nil {
-			log.Printf("failed to remove work dir: %v", err)
+			return err
 		}
-	}()
-
-	if *verbose {
-		log.Printf("download %s", src.String())
-	}
-	zipPath, err := download(ctx, gcs, workDir, src)
-	if err != nil {
-		return fmt.Errorf("download zip: %w", err)
-	}
-	if *verbose {
-		log.Printf("download finished: -> %s", zipPath)
-	}
-
-	bucket := gcs.Bucket(dest.Hostname())
-
-	uploadBufPool := sync.Pool{
-		New: func() any {
-			return make([]byte, *bufSize)
-		},
-	}
-	useGzip := map[string]bool{}
-	if *gzipExt != "" {
-		for _, ext := range strings.Split(*gzipExt, ",") {
-			useGzip["."+strings.ToLower(ext)] = true
+		srcs = []string{cfg.Shard.Src}
+	} else {
+		srcs = fs.Args()[:fs.NArg()-1]
+		cfg.Src, cfg.Dest = srcs[0], fs.Arg(fs.NArg()-1)
+		cfg.ShardIndex, cfg.ShardCount = index, count
+	}
+	if len(srcs) > 1 {
+		// the outputs of the archives would overwrite each other.
+		if cfg.Manifest != "" || cfg.ErrorReport != "" || cfg.Checkpoint != "" || *progressJSON != "" || *summaryJSON != "" {
+			return fmt.Errorf("%w: -manifest, -error-report, -checkpoint, -progress-json and -summary-json cannot be used with multiple sources", gcsunzip.ErrUsage)
 		}
+		cfg.Budget = gcsunzip.NewBudget(cfg.DiskLimit, *memoryLimit, cfg.Concurrency)
 	}
-	gzipWriterPool := sync.Pool{
-		New: func() any {
-			return gzip.NewWriter(io.Discard)
-		},
-	}
-	var count atomic.Int64
-	uploadsStart := time.Now()
 
-	upload := func(ctx context.Context, f string) error {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-		}
-		r, err := os.Open(filepath.Join(workDir, f))
-		if err != nil {
-			return fmt.Errorf("open upload file: %w", err)
-		}
-		defer r.Close()
-
-		name := path.Join(dest.Path[1:], filepath.ToSlash(f))
-		o := bucket.Object(name).Retryer(storage.WithPolicy(storage.RetryAlways))
-		ow := o.NewWriter(ctx)
-		ow.ChunkSize = int(*chunkSize)
-		defer ow.Close()
-
-		var w io.Writer
-		var closeWriter func() error
-		if useGzip[strings.ToLower(filepath.Ext(f))] {
-			if sniff, err := io.ReadAll(io.NewSectionReader(r, 0, 512)); err == nil {
-				ow.ContentType = http.DetectContentType(sniff)
-			}
-			ow.ContentEncoding = "gzip"
-			gw := gzipWriterPool.Get().(*gzip.Writer)
-			defer gzipWriterPool.Put(gw)
-			gw.Reset(ow)
-
-			closeWriter = func() error {
-				if err := gw.Close(); err != nil {
-					return err
-				}
-				return ow.Close()
+	if *progressJSON != "" {
+		cfg.Progress = os.Stdout
+		if *progressJSON != "-" {
+			// a named pipe blocks here until the reader opens it.
+			f, err := os.OpenFile(*progressJSON, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+			if err != nil {
+				return fmt.Errorf("open progress: %w", err)
 			}
-			w = gw
-		} else {
-			closeWriter = ow.Close
-			w = ow
-		}
-
-		buf := uploadBufPool.Get().([]byte)
-		defer uploadBufPool.Put(buf)
-
-		var start time.Time
-		if *verbose {
-			start = time.Now()
-		}
-		uploaded, err := io.CopyBuffer(w, r, buf)
-		if err != nil {
-			return fmt.Errorf("upload: %w", err)
-		}
-		if err := closeWriter(); err != nil {
-			return fmt.Errorf("close writer: %w", err)
-		}
-		c := count.Add(1)
-		if *gcInterval > 0 && int(c)%*gcInterval == 0 {
-			runtime.GC()
-		}
-		if *verbose {
-			log.Printf("%7d: -> %s(%s): %s", c, "gs://"+path.Join(o.BucketName(), o.ObjectName()), bytesString(uint64(uploaded)), time.Now().Sub(start))
-		}
-		return nil
-	}
-	if local {
-		upload = func(ctx context.Context, f string) error {
-			log.Printf("-> %s", f)
-			return nil
+			defer f.Close()
+			cfg.Progress = f
 		}
 	}
 
-	zf, err := os.Open(zipPath)
+	stopProfiling, err := startProfiling(*pprofAddr, *traceFile)
 	if err != nil {
-		return fmt.Errorf("open zip file: %w", err)
+		return err
 	}
-	defer zf.Close()
-
-	archiveName := trimExt(filepath.Base(zf.Name()))
-
-	extractor, err := NewExtractor(zf, *oldWindows)
+	defer stopProfiling()
+	shutdownTracing, err := setupTracing(context.Background(), *otelEndpoint)
 	if err != nil {
-		return fmt.Errorf("extractor: %w", err)
+		return err
 	}
-
-	var largestFile string
-	var largestSize uint64
-	filesCount := 0
-
-	topDirOnly := true
-	for i := 0; i < extractor.Files(); i++ {
-		if extractor.IsDir(i) {
-			continue
-		}
-		name := extractor.FileName(i)
-		if !*withMeta && isIgnoreMeta(name) {
-			continue
-		}
-		if *skipTop && topDirOnly {
-			top, _, _ := strings.Cut(name, string(os.PathSeparator))
-			if top != archiveName {
-				topDirOnly = false
-			}
-		}
-
-		filesCount++
-		size := extractor.FileSize(i)
-		if largestSize < size {
-			largestFile = name
-			largestSize = size
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn(fmt.Sprintf("failed to export traces: %v", err))
 		}
-	}
-	if *diskLimit < largestSize {
-		return fmt.Errorf("no enough space(%s): %s", largestFile, bytesString(largestSize))
-	}
-
-	if *verbose {
-		log.Printf("files: %d", filesCount)
-	}
-
-	uploadGroup, ctx := errgroup.WithContext(ctx)
-	uploadGroup.SetLimit(*n + 1)
-	diskSem := semaphore.NewWeighted(int64(*diskLimit))
-
-	type uploadJob struct {
-		name string
-		size int64
-	}
-	uploadJobCh := make(chan uploadJob, filesCount)
+	}()
 
-	uploadGroup.Go(func() error {
-		for {
-			var size int64
-			var name string
-			select {
-			case <-ctx.Done():
-				return nil
-			case job, ok := <-uploadJobCh:
-				if !ok {
-					return nil
-				}
-				size = job.size
-				name = job.name
+	ctx := context.Background()
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, *deadline, gcsunzip.ErrDeadlineExceeded)
+		defer cancel()
+		jobCtx := ctx
+		defer func() {
+			if err != nil && errors.Is(context.Cause(jobCtx), gcsunzip.ErrDeadlineExceeded) && !errors.Is(err, gcsunzip.ErrDeadlineExceeded) {
+				err = fmt.Errorf("%w: %w", gcsunzip.ErrDeadlineExceeded, err)
 			}
-			uploadGroup.Go(func() error {
-				defer diskSem.Release(size)
-				defer func() {
-					if local {
-						return
-					}
-					err := os.Remove(filepath.Join(workDir, name))
-					if err != nil {
-						log.Printf("failed to remove temp file: %v", err)
-					}
-				}()
-				return upload(ctx, name)
-			})
-		}
-	})
-
-FILES:
-	for i := 0; i < extractor.Files(); i++ {
+		}()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	// stop is closed on SIGINT/SIGTERM, which stops extracting new entries,
+	// and in-flight uploads are canceled after -shutdown-timeout.
+	stop := make(chan struct{})
+	cfg.Stop = stop
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
 		select {
 		case <-ctx.Done():
-			break FILES
-		default:
-		}
-		name := extractor.FileName(i)
-		if !*withMeta && isIgnoreMeta(name) {
-			continue
+			return
+		case sig := <-sigCh:
+			signal.Stop(sigCh)
+			slog.Warn(fmt.Sprintf("%v received, waiting up to %s for in-flight uploads", sig, *shutdownTimeout))
+			close(stop)
 		}
-		if *skipTop && topDirOnly {
-			name = strings.TrimPrefix(name, archiveName)
-			if name != "" {
-				name = name[1:]
-			}
-		}
-		name = filepath.Join(archiveName, name)
-		if extractor.IsDir(i) {
-			if err := os.MkdirAll(filepath.Join(workDir, name), 0700); err != nil {
-				return fmt.Errorf("mkdir: %w", err)
-			}
-			continue
-		}
-		size := int64(extractor.FileSize(i))
-		if err := diskSem.Acquire(ctx, size); err != nil {
-			return fmt.Errorf("acquire disk sem: %w", err)
-		}
-
-		if err := writeTemporary(ctx, extractor, i, name, workDir); err != nil {
-			return fmt.Errorf("write temp: %w", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(*shutdownTimeout):
+			cancel(gcsunzip.ErrInterrupted)
 		}
-		uploadJobCh <- uploadJob{name: name, size: size}
-	}
-	close(uploadJobCh)
-
-	if err := uploadGroup.Wait(); err != nil {
-		return fmt.Errorf("uploads: %w", err)
-	}
-	log.Printf("total: %s", time.Now().Sub(uploadsStart))
-	return nil
-}
-
-func main() {
-	log.SetPrefix("gcs-unzip: ")
-	if err := run(); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func flagBytes(name string, value uint64, usage string) *uint64 {
-	p := new(uint64)
-	*p = value
-	flag.Var((*bytesValue)(p), name, usage)
-	return p
-}
-
-var bytesUnits = []struct {
-	suffix string
-	value  uint64
-}{
-	{"g", 1 * 1024 * 1024 * 1024},
-	{"m", 1 * 1024 * 1024},
-	{"k", 1 * 1024},
-	{"gb", 1 * 1024 * 1024 * 1024},
-	{"mb", 1 * 1024 * 1024},
-	{"kb", 1 * 1024},
-	{"b", 1},
-	{"", 1},
-}
-
-type bytesValue uint64
+	}()
 
-func (b *bytesValue) String() string {
-	for _, u := range bytesUnits {
-		if uint64(*b) >= u.value {
-			return strconv.FormatUint(uint64(*b)/u.value, 10) + u.suffix
+	if len(srcs) == 1 {
+		report, err := gcsunzip.Run(ctx, cfg)
+		if *summaryJSON != "" {
+			if werr := writeSummary(*summaryJSON, gcsunzip.NewSummary(cfg, report, err)); werr != nil {
+				slog.Error(fmt.Sprintf("failed to write summary: %v", werr))
+			}
 		}
+		return err
 	}
-	return "0"
+	return extractAll(ctx, cfg, srcs, *jobs)
 }
 
-func (b *bytesValue) Set(s string) error {
-	x := strings.ToLower(s)
-	for _, u := range bytesUnits {
-		if !strings.HasSuffix(x, u.suffix) {
-			continue
-		}
-		v, err := strconv.ParseUint(strings.TrimSuffix(x, u.suffix), 10, 64)
-		if err != nil {
-			return fmt.Errorf("parse(%s): %w", s, err)
-		}
-		*b = bytesValue(v * u.value)
-		return nil
+// extractAll extracts the archives with up to jobs at once, which share cfg.Budget.
+// It returns the errors of the failed archives, and the others continue.
+func extractAll(ctx context.Context, cfg gcsunzip.Config, srcs []string, jobs int) error {
+	var mu sync.Mutex
+	var errs []error
+	var eg errgroup.Group
+	eg.SetLimit(max(jobs, 1))
+	for _, src := range srcs {
+		c := cfg
+		c.Src = src
+		eg.Go(func() error {
+			if _, err := gcsunzip.Run(ctx, c); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", src, err))
+				mu.Unlock()
+			}
+			return nil
+		})
 	}
-	panic("unreachable")
-}
-
-func bytesString(x uint64) string {
-	bv := bytesValue(x)
-	return bv.String()
+	eg.Wait()
+	return errors.Join(errs...)
 }
 
-func parseGSURL(s string) (*url.URL, error) {
-	u, err := url.ParseRequestURI(s)
+// writeSummary writes s as JSON to the file, or stdout if it is -.
+func writeSummary(name string, s gcsunzip.Summary) error {
+	b, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
-		return nil, fmt.Errorf("parse uri: %w", err)
-	}
-	if local {
-		return u, nil
+		return err
 	}
-	if u.Scheme != "gs" {
-		return nil, fmt.Errorf("must start with gs://: %s", u.Scheme)
+	b = append(b, '\n')
+	if name == "-" {
+		_, err := os.Stdout.Write(b)
+		return err
 	}
-	return u, nil
+	return os.WriteFile(name, b, 0o644)
 }
 
-func download(ctx context.Context, gcs *storage.Client, workDir string, src *url.URL) (string, error) {
-	if local {
-		return strings.TrimPrefix(src.Path, "/"), nil
-	}
-	r, err := gcs.Bucket(src.Hostname()).Object(src.Path[1:]).NewReader(ctx)
-	if err != nil {
-		return "", fmt.Errorf("src reader: %w", err)
-	}
-	defer r.Close()
-	p := filepath.Join(workDir, path.Base(src.Path))
-	f, err := os.Create(p)
-	if err != nil {
-		return "", fmt.Errorf("create tmp file: %w", err)
-	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, r); err != nil {
-		return "", fmt.Errorf("copy: %w", err)
-	}
-	if err := f.Close(); err != nil {
-		return "", fmt.Errorf("close tmp file: %w", err)
-	}
-	return p, nil
+// logFlags are the flags of logging of the subcommands running jobs.
+type logFlags struct {
+	verbose *bool
+	level   *string
+	format  *string
+	quiet   *bool
 }
 
-func writeTemporary(ctx context.Context, e Extractor, i int, name, workDir string) error {
-	rc, err := e.Open(i)
-	if err != nil {
-		return fmt.Errorf("open zip entry(%s): %w", name, err)
+func defineLogFlags(fs *flag.FlagSet) *logFlags {
+	return &logFlags{
+		verbose: fs.Bool("v", false, "show verbose output (same as -log-level debug)"),
+		level:   fs.String("log-level", "info", "minimum level of logs: error, warn, info or debug"),
+		format:  fs.String("log-format", "text", "format of logs: text or json"),
+		quiet:   fs.Bool("quiet", false, "show only errors (same as -log-level error)"),
 	}
-	defer rc.Close()
+}
 
-	tmpFile := filepath.Join(workDir, name)
-	f, err := os.Create(tmpFile)
-	if errors.Is(err, fs.ErrNotExist) {
-		if err := os.MkdirAll(filepath.Dir(tmpFile), 0700); err != nil {
-			return fmt.Errorf("mkdir all: %w", err)
-		}
-		f, err = os.Create(tmpFile)
-	}
+// setup replaces the default logger by the flags.
+func (l *logFlags) setup() error {
+	level, err := parseLogLevel(*l.level)
 	if err != nil {
-		return fmt.Errorf("create: %w", err)
-	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, rc); err != nil {
-		return fmt.Errorf("copy: %w", err)
-	}
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("close: %w", err)
+		return fmt.Errorf("%w: invalid -log-level: %w", gcsunzip.ErrUsage, err)
+	}
+	switch {
+	case *l.quiet:
+		level = slog.LevelError
+	case *l.verbose:
+		level = slog.LevelDebug
+	}
+	switch *l.format {
+	case "text":
+		slog.SetDefault(slog.New(newTextHandler(os.Stderr, level)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	default:
+		return fmt.Errorf("%w: invalid -log-format: %s", gcsunzip.ErrUsage, *l.format)
 	}
 	return nil
 }
 
-func isIgnoreMeta(name string) bool {
-	rest := name
-	sep := string(os.PathSeparator)
-	for rest != "" {
-		n, after, found := strings.Cut(rest, sep)
-		if !found {
-			return n == ".DS_Store" || n == "Thumbs.db" || n == "__MACOSX"
+func main() {
+	slog.SetDefault(slog.New(newTextHandler(os.Stderr, slog.LevelInfo)))
+	commands := []struct {
+		name  string
+		usage string
+		run   func([]string) error
+	}{
+		{"extract", "extract an archive and upload the entries (default)", runExtract},
+		{"list", "print the entries of an archive", runList},
+		{"verify", "compare an archive with an extracted destination", runVerify},
+		{"serve", "run an HTTP server which extracts archives on requests", runServe},
+		{"dispatch", "enqueue Cloud Tasks which extract ranges of entries by serve", runDispatch},
+		{"plan", "write shard specs of an archive for workers with -shard-spec", runPlan},
+		{"archive", "write the objects under a prefix into an archive", runArchive},
+		{"convert", "re-package an archive into another format", runConvert},
+		{"index", "write the central directory of a zip as a JSON index", runIndex},
+	}
+	args := os.Args[1:]
+	run := runExtract
+	if len(args) > 0 && args[0] == "help" {
+		fmt.Fprintf(os.Stderr, "Usage of gcs-unzip <command> [options] <args>:\n")
+		for _, c := range commands {
+			fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.usage)
 		}
-		rest = after
-		if n == "__MACOSX" {
-			return true
+		fmt.Fprintf(os.Stderr, "\n\"gcs-unzip <src> <dest>\" is the same as \"gcs-unzip extract <src> <dest>\".\n")
+		return
+	}
+	for _, c := range commands {
+		if len(args) > 0 && args[0] == c.name {
+			run, args = c.run, args[1:]
+			break
 		}
 	}
-	return false
-}
-
-func trimExt(name string) string {
-	return strings.TrimSuffix(name, filepath.Ext(name))
+	if err := run(args); err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelError, err.Error(),
+			slog.String("event", "error"),
+			slog.String("error", err.Error()),
+			slog.Int("exit_code", exitCode(err)),
+		)
+		os.Exit(exitCode(err))
+	}
 }
@@ -0,0 +1,108 @@
+// Package funcs runs the pipeline for archives finalized in a bucket, as a CloudEvent function of
+// Cloud Run functions or a Cloud Run service triggered by Eventarc.
+//
+// The options are read once from the same environment variables as the CLI, e.g. GCS_UNZIP_GZIP_EXT for
+// -gzip-ext, and GCS_UNZIP_CONFIG for a YAML file of them. GCS_UNZIP_DEST is the destination, which is required,
+// and GCS_UNZIP_SRC_PREFIX limits the objects to extract, e.g. to avoid extracting archives in the destination.
+//
+// Register Extract with the Functions Framework:
+//
+//	func init() {
+//		functions.CloudEvent("Extract", funcs.Extract)
+//	}
+//
+// or serve Handler on Cloud Run.
+package funcs
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// finalizedType is the type of the events of new objects.
+const finalizedType = "google.cloud.storage.object.v1.finalized"
+
+type options struct {
+	cfg       gcsunzip.Config
+	srcPrefix string
+}
+
+var loadOptions = sync.OnceValues(func() (options, error) {
+	fs := flag.NewFlagSet("funcs", flag.ContinueOnError)
+	extractConfig := cliflag.DefineExtract(fs)
+	dest := fs.String("dest", "", "destination prefix")
+	srcPrefix := fs.String("src-prefix", "", "prefix of object names to extract")
+	fs.String("config", "", "YAML file of flags")
+	if err := fs.Parse(nil); err != nil {
+		return options{}, err
+	}
+	if err := cliflag.Load(fs); err != nil {
+		return options{}, err
+	}
+	if *dest == "" {
+		return options{}, fmt.Errorf("%w: GCS_UNZIP_DEST is required", gcsunzip.ErrUsage)
+	}
+	cfg, err := extractConfig()
+	if err != nil {
+		return options{}, err
+	}
+	cfg.Dest = *dest
+	return options{cfg: cfg, srcPrefix: *srcPrefix}, nil
+})
+
+// storageObjectData is the part of the data of storage events which is used.
+type storageObjectData struct {
+	Bucket string `json:"bucket"`
+	Name   string `json:"name"`
+}
+
+// Extract extracts the object of a google.cloud.storage.object.v1.finalized event.
+// Other events, objects outside GCS_UNZIP_SRC_PREFIX and unsupported formats are ignored without errors.
+// An error is returned for failed jobs, which are retried if the trigger retries.
+func Extract(ctx context.Context, e event.Event) error {
+	if e.Type() != finalizedType {
+		slog.Debug(fmt.Sprintf("ignore %s event", e.Type()))
+		return nil
+	}
+	var obj storageObjectData
+	if err := e.DataAs(&obj); err != nil {
+		return fmt.Errorf("decode data: %w", err)
+	}
+	opts, err := loadOptions()
+	if err != nil {
+		return fmt.Errorf("options: %w", err)
+	}
+	if !strings.HasPrefix(obj.Name, opts.srcPrefix) || strings.HasSuffix(obj.Name, "/") {
+		slog.Debug(fmt.Sprintf("ignore gs://%s/%s", obj.Bucket, obj.Name))
+		return nil
+	}
+	// the format is checked by the name, because ErrUnsupportedFormat of Run can also be of an entry.
+	if !gcsunzip.SupportedFormat(obj.Name) {
+		slog.Debug(fmt.Sprintf("ignore gs://%s/%s: unsupported format", obj.Bucket, obj.Name))
+		return nil
+	}
+	cfg := opts.cfg
+	cfg.Src = "gs://" + obj.Bucket + "/" + obj.Name
+	_, err = gcsunzip.Run(ctx, cfg)
+	return err
+}
+
+// Handler returns an HTTP handler receiving CloudEvents for Extract, e.g. on Cloud Run with an Eventarc trigger.
+func Handler(ctx context.Context) (http.Handler, error) {
+	p, err := cloudevents.NewHTTP()
+	if err != nil {
+		return nil, err
+	}
+	return cloudevents.NewHTTPReceiveHandler(ctx, p, Extract)
+}
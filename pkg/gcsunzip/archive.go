@@ -0,0 +1,289 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
+)
+
+// archiveReadAheadSize is the maximum size of the objects which are read ahead into memory,
+// so that small objects are not archived one request at a time.
+const archiveReadAheadSize = 1024 * 1024
+
+// ArchiveConfig configures Archive.
+type ArchiveConfig struct {
+	// Src is the gs:// URL of the prefix of the objects to archive.
+	Src string
+	// Dest is the gs:// URL of the archive, whose format is given by the extension: .zip, .tar, .tar.gz (.tgz) or .tar.zst (.tzst).
+	Dest string
+	// Client is used for GCS requests. A client is created if nil.
+	Client *storage.Client
+	// Concurrency is the number of objects up to 1MiB read ahead (default 16).
+	Concurrency int
+	// ChunkSize is the chunk size of the upload of the archive (default 16MiB).
+	ChunkSize int
+	// TmpDir is the directory of temporary files of the objects with Content-Encoding in tar archives,
+	// which need the decompressed sizes before the contents.
+	TmpDir string
+	// EncryptionKey is the AES-256 customer-supplied key of the objects and the archive.
+	EncryptionKey []byte
+}
+
+// ArchiveReport is the result of Archive and Convert.
+type ArchiveReport struct {
+	// Files and Bytes are the number and the total size of the archived files.
+	Files int64
+	Bytes int64
+	// Size is the size of the archive.
+	Size int64
+}
+
+// archiveObject is an object to archive, whose content is read ahead if it is small.
+type archiveObject struct {
+	attrs *storage.ObjectAttrs
+	// done is closed when data is read, or immediately if the object is not read ahead.
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Archive writes the objects under a prefix into an archive on GCS, which is the reverse of Run.
+// Objects are streamed in the order of their names, so the memory is bounded by ChunkSize and the read-ahead.
+// Names ending with "/" are directories, the objects with the symlink-target metadata are symlinks,
+// and the objects with Content-Encoding are decompressed. The archive is not created if any object fails.
+func Archive(ctx context.Context, cfg ArchiveConfig) (ArchiveReport, error) {
+	var report ArchiveReport
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 16
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 16 * 1024 * 1024
+	}
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	dest, err := parseGSURL(cfg.Dest)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse dest: %w", ErrUsage, err)
+	}
+	format, err := archiveFormatOf(dest.Path)
+	if err != nil {
+		return report, err
+	}
+	if cfg.EncryptionKey != nil && len(cfg.EncryptionKey) != 32 {
+		return report, fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+	}
+	prefix := strings.TrimPrefix(src.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	destName := strings.TrimPrefix(dest.Path, "/")
+
+	gcs := cfg.Client
+	if gcs == nil {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return report, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	object := func(bucket, name string) *storage.ObjectHandle {
+		o := gcs.Bucket(bucket).Object(name)
+		if cfg.EncryptionKey != nil {
+			o = o.Key(cfg.EncryptionKey)
+		}
+		return o
+	}
+
+	// the archive is not created if ctx is canceled before Close.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ow := object(dest.Hostname(), destName).Retryer(storage.WithPolicy(storage.RetryAlways)).NewWriter(ctx)
+	ow.ChunkSize = cfg.ChunkSize
+	ow.ContentType = format.contentType
+	aw, err := format.new(ow)
+	if err != nil {
+		cancel()
+		ow.Close()
+		return report, fmt.Errorf("archive writer: %w", err)
+	}
+
+	start := time.Now()
+	eg, gctx := errgroup.WithContext(ctx)
+	queue := make(chan *archiveObject, cfg.Concurrency)
+	sem := make(chan struct{}, cfg.Concurrency)
+	eg.Go(func() error {
+		defer close(queue)
+		it := gcs.Bucket(src.Hostname()).Objects(gctx, &storage.Query{Prefix: prefix})
+		for {
+			attrs, err := it.Next()
+			if errors.Is(err, iterator.Done) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("list objects: %w", err)
+			}
+			if attrs.Bucket == dest.Hostname() && attrs.Name == destName {
+				continue
+			}
+			a := &archiveObject{attrs: attrs, done: make(chan struct{})}
+			if attrs.Size <= archiveReadAheadSize && attrs.ContentEncoding == "" && !strings.HasSuffix(attrs.Name, "/") && attrs.Metadata["symlink-target"] == "" {
+				select {
+				case sem <- struct{}{}:
+				case <-gctx.Done():
+					return nil
+				}
+				eg.Go(func() error {
+					defer func() { <-sem }()
+					defer close(a.done)
+					a.data, a.err = readObject(gctx, object(attrs.Bucket, attrs.Name).Generation(attrs.Generation))
+					return nil
+				})
+			} else {
+				close(a.done)
+			}
+			select {
+			case queue <- a:
+			case <-gctx.Done():
+				return nil
+			}
+		}
+	})
+	eg.Go(func() error {
+		for a := range queue {
+			select {
+			case <-a.done:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			name := strings.TrimPrefix(a.attrs.Name, prefix)
+			n, err := archiveEntry(gctx, aw, format, cfg.TmpDir, object(a.attrs.Bucket, a.attrs.Name).Generation(a.attrs.Generation), name, a)
+			if err != nil {
+				return fmt.Errorf("%s: %w", a.attrs.Name, err)
+			}
+			if n < 0 {
+				continue
+			}
+			report.Files++
+			report.Bytes += n
+			debugf("%7d: gs://%s/%s(%s)", report.Files, a.attrs.Bucket, a.attrs.Name, FormatBytes(uint64(n)))
+		}
+		return nil
+	})
+	if err := eg.Wait(); err != nil {
+		cancel()
+		ow.Close()
+		return report, err
+	}
+	if err := aw.Close(); err != nil {
+		cancel()
+		ow.Close()
+		return report, fmt.Errorf("close archive: %w", err)
+	}
+	if err := ow.Close(); err != nil {
+		return report, fmt.Errorf("close writer: %w", err)
+	}
+	report.Size = ow.Attrs().Size
+	logEvent(slog.LevelInfo, []slog.Attr{
+		slog.String("event", "archive"),
+		slog.String("dest", cfg.Dest),
+		slog.Int64("files", report.Files),
+		slog.Int64("bytes", report.Bytes),
+		slog.Int64("size", report.Size),
+		slog.Duration("duration", time.Since(start)),
+	}, "archive: %d files, %s -> %s(%s): %s", report.Files, FormatBytes(uint64(report.Bytes)), cfg.Dest, FormatBytes(uint64(report.Size)), time.Since(start).Round(time.Millisecond))
+	return report, nil
+}
+
+// archiveEntry writes an object as the entry name, and returns the size of the file or -1 if it is not a file.
+func archiveEntry(ctx context.Context, aw archiveWriter, format archiveFormat, tmpDir string, o *storage.ObjectHandle, name string, a *archiveObject) (int64, error) {
+	mtime := a.attrs.Updated
+	switch {
+	case name == "" || name == "/":
+		// the placeholder of the prefix itself.
+		return -1, nil
+	case strings.HasSuffix(name, "/"):
+		return -1, aw.Mkdir(strings.TrimSuffix(name, "/"), mtime)
+	case a.attrs.Metadata["symlink-target"] != "":
+		return -1, aw.Symlink(name, a.attrs.Metadata["symlink-target"], mtime)
+	}
+	if a.err != nil {
+		return 0, a.err
+	}
+	if a.data != nil || a.attrs.Size == 0 {
+		return writeArchiveFile(aw, format, tmpDir, name, int64(len(a.data)), 0o644, mtime, bytes.NewReader(a.data))
+	}
+
+	r, err := o.NewReader(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reader: %w", err)
+	}
+	defer r.Close()
+	dr, err := newDecoder(a.attrs.ContentEncoding, r)
+	if err != nil {
+		return 0, fmt.Errorf("decoder: %w", err)
+	}
+	defer dr.Close()
+	size := a.attrs.Size
+	if a.attrs.ContentEncoding != "" {
+		// the decompressed size is unknown until the end.
+		size = -1
+	}
+	return writeArchiveFile(aw, format, tmpDir, name, size, 0o644, mtime, dr)
+}
+
+// writeArchiveFile writes a file entry of size bytes read from r, where size is -1 if unknown.
+// The content is buffered in a temporary file if the format needs the unknown size before it.
+func writeArchiveFile(aw archiveWriter, format archiveFormat, tmpDir, name string, size int64, mode fs.FileMode, mtime time.Time, r io.Reader) (int64, error) {
+	if size < 0 && format.sized {
+		tmp, err := os.CreateTemp(tmpDir, "archive-*")
+		if err != nil {
+			return 0, fmt.Errorf("create tmp file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+		size, err = io.Copy(tmp, r)
+		if err != nil {
+			return 0, fmt.Errorf("read: %w", err)
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("seek: %w", err)
+		}
+		r = tmp
+	}
+	w, err := aw.Create(name, size, mode, mtime)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, fmt.Errorf("copy: %w", err)
+	}
+	return n, nil
+}
+
+// readObject reads the content of a small object.
+func readObject(ctx context.Context, o *storage.ObjectHandle) ([]byte, error) {
+	r, err := o.NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reader: %w", err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,175 @@
+package gcsunzip
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFS returns a read-only fs.FS of the entries of the archive, which can be traversed with fs.WalkDir.
+// Names are slash-separated and cleaned, directories which are not entries of the archive are synthesized,
+// and entries whose names are not valid paths such as "../a" are omitted. The last of duplicate entries wins.
+func ArchiveFS(e Extractor) fs.FS {
+	a := &archiveFS{e: e, nodes: map[string]*archiveNode{".": {index: -1, dir: true}}}
+	for i := 0; i < e.Files(); i++ {
+		name := strings.TrimPrefix(path.Clean(filepath.ToSlash(e.FileName(i))), "/")
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		a.add(name, i)
+	}
+	return a
+}
+
+type archiveFS struct {
+	e     Extractor
+	nodes map[string]*archiveNode
+}
+
+type archiveNode struct {
+	index    int // -1 for synthesized directories
+	dir      bool
+	children map[string]bool
+}
+
+func (a *archiveFS) add(name string, i int) {
+	n, ok := a.nodes[name]
+	if !ok {
+		n = &archiveNode{}
+		a.nodes[name] = n
+	}
+	n.index = i
+	n.dir = a.e.IsDir(i) || len(n.children) > 0
+	for child := name; child != "."; child = path.Dir(child) {
+		p := path.Dir(child)
+		pn, ok := a.nodes[p]
+		if !ok {
+			pn = &archiveNode{index: -1}
+			a.nodes[p] = pn
+		}
+		pn.dir = true
+		if pn.children == nil {
+			pn.children = map[string]bool{}
+		}
+		pn.children[path.Base(child)] = true
+		if ok {
+			break
+		}
+	}
+}
+
+func (a *archiveFS) info(name string, n *archiveNode) *archiveFileInfo {
+	return &archiveFileInfo{name: path.Base(name), e: a.e, node: n}
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := a.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if n.dir {
+		return &archiveDir{fs: a, name: name, node: n}, nil
+	}
+	rc, err := a.e.Open(n.index)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &archiveFile{info: a.info(name, n), rc: rc}, nil
+}
+
+type archiveFileInfo struct {
+	name string
+	e    Extractor
+	node *archiveNode
+}
+
+func (fi *archiveFileInfo) Name() string { return fi.name }
+
+func (fi *archiveFileInfo) Size() int64 {
+	if fi.node.dir {
+		return 0
+	}
+	return int64(fi.e.FileSize(fi.node.index))
+}
+
+func (fi *archiveFileInfo) Mode() fs.FileMode {
+	if fi.node.index < 0 {
+		return fs.ModeDir | 0o555
+	}
+	m := fi.e.Mode(fi.node.index)
+	if fi.node.dir {
+		m |= fs.ModeDir
+	}
+	return m
+}
+
+func (fi *archiveFileInfo) ModTime() time.Time {
+	if fi.node.index < 0 {
+		return time.Time{}
+	}
+	return fi.e.ModTime(fi.node.index)
+}
+
+func (fi *archiveFileInfo) IsDir() bool { return fi.node.dir }
+func (fi *archiveFileInfo) Sys() any    { return nil }
+
+type archiveFile struct {
+	info *archiveFileInfo
+	rc   io.ReadCloser
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *archiveFile) Read(p []byte) (int, error) { return f.rc.Read(p) }
+func (f *archiveFile) Close() error               { return f.rc.Close() }
+
+type archiveDir struct {
+	fs      *archiveFS
+	name    string
+	node    *archiveNode
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.fs.info(d.name, d.node), nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) Close() error { return nil }
+
+func (d *archiveDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		names := make([]string, 0, len(d.node.children))
+		for name := range d.node.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		d.entries = make([]fs.DirEntry, len(names))
+		for i, name := range names {
+			p := path.Join(d.name, name)
+			d.entries[i] = fs.FileInfoToDirEntry(d.fs.info(p, d.fs.nodes[p]))
+		}
+	}
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,137 @@
+package gcsunzip
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveWriter writes the entries of an archive in order.
+type archiveWriter interface {
+	// Create starts a file entry of size bytes with the permission bits of mode, and returns the writer
+	// of its content until the next entry. The size is -1 if unknown and the format is not sized.
+	Create(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error)
+	Mkdir(name string, mtime time.Time) error
+	Symlink(name, target string, mtime time.Time) error
+	// Close writes the end of the archive without closing the underlying writer.
+	Close() error
+}
+
+// archiveFormat is a format of archives written by Archive and Convert.
+type archiveFormat struct {
+	exts        []string
+	contentType string
+	// sized formats need the size of a file before its content.
+	sized bool
+	new   func(w io.Writer) (archiveWriter, error)
+}
+
+var archiveFormats = []archiveFormat{
+	{exts: []string{".zip"}, contentType: "application/zip", new: newZipWriter},
+	{exts: []string{".tar"}, contentType: "application/x-tar", sized: true, new: newTarWriter(nil)},
+	{exts: []string{".tar.gz", ".tgz"}, contentType: "application/gzip", sized: true, new: newTarWriter(func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	})},
+	{exts: []string{".tar.zst", ".tzst"}, contentType: "application/zstd", sized: true, new: newTarWriter(func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	})},
+}
+
+// archiveFormatOf returns the format of the archive by the extension of name.
+func archiveFormatOf(name string) (archiveFormat, error) {
+	lower := strings.ToLower(name)
+	for _, f := range archiveFormats {
+		for _, ext := range f.exts {
+			if strings.HasSuffix(lower, ext) {
+				return f, nil
+			}
+		}
+	}
+	return archiveFormat{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, path.Ext(name))
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func newZipWriter(w io.Writer) (archiveWriter, error) {
+	return &zipWriter{zw: zip.NewWriter(w)}, nil
+}
+
+func (z *zipWriter) Create(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error) {
+	h := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime}
+	h.SetMode(mode.Perm())
+	return z.zw.CreateHeader(h)
+}
+
+func (z *zipWriter) Mkdir(name string, mtime time.Time) error {
+	_, err := z.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Method: zip.Store, Modified: mtime})
+	return err
+}
+
+func (z *zipWriter) Symlink(name, target string, mtime time.Time) error {
+	h := &zip.FileHeader{Name: name, Method: zip.Store, Modified: mtime}
+	h.SetMode(fs.ModeSymlink | 0o777)
+	w, err := z.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, target)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	// c is the compressor between tw and the underlying writer, or nil.
+	c io.WriteCloser
+}
+
+func newTarWriter(compress func(io.Writer) (io.WriteCloser, error)) func(io.Writer) (archiveWriter, error) {
+	return func(w io.Writer) (archiveWriter, error) {
+		if compress == nil {
+			return &tarWriter{tw: tar.NewWriter(w)}, nil
+		}
+		c, err := compress(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(c), c: c}, nil
+	}
+}
+
+func (t *tarWriter) Create(name string, size int64, mode fs.FileMode, mtime time.Time) (io.Writer, error) {
+	if err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: int64(mode.Perm()), ModTime: mtime}); err != nil {
+		return nil, err
+	}
+	return t.tw, nil
+}
+
+func (t *tarWriter) Mkdir(name string, mtime time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: mtime})
+}
+
+func (t *tarWriter) Symlink(name, target string, mtime time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0o777, ModTime: mtime})
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.c != nil {
+		return t.c.Close()
+	}
+	return nil
+}
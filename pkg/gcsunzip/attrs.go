@@ -0,0 +1,104 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileExt returns the lower-cased extension of name without the dot, which is the key of per-extension attributes.
+func fileExt(name string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+// normalizeExts returns a copy of the per-extension map whose keys are normalized by normalizeExt.
+func normalizeExts(m map[string]string) map[string]string {
+	n := make(map[string]string, len(m))
+	for k, v := range m {
+		n[normalizeExt(k)] = v
+	}
+	return n
+}
+
+// extAttr returns the value for the extension of name in overrides, or def.
+func extAttr(name, def string, overrides map[string]string) string {
+	if v, ok := overrides[fileExt(name)]; ok {
+		return v
+	}
+	return def
+}
+
+// expandDisposition replaces {name} in the Content-Disposition template with the quoted-string escaped base name of name.
+func expandDisposition(tmpl, name string) string {
+	if !strings.Contains(tmpl, "{name}") {
+		return tmpl
+	}
+	base := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(filepath.Base(name))
+	return strings.ReplaceAll(tmpl, "{name}", base)
+}
+
+// precompressed reports whether the file is already compressed, such as gzip, zstd and most image formats,
+// which is not worth compressing again. encoding is the Content-Encoding if it is a gzip or zstd stream.
+func precompressed(name string) (encoding string, ok bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, 512))
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case bytes.HasPrefix(b, []byte{0x1f, 0x8b}):
+		return "gzip", true
+	case bytes.HasPrefix(b, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd", true
+	}
+	switch typ := http.DetectContentType(b); {
+	case typ == "image/png", typ == "image/jpeg", typ == "image/gif", typ == "image/webp",
+		typ == "application/zip", typ == "application/x-rar-compressed",
+		strings.HasPrefix(typ, "video/"), typ == "audio/mpeg", typ == "font/woff", typ == "font/woff2":
+		return "", true
+	}
+	return "", false
+}
+
+// sniffContentType detects the content type of the file from its first 512 bytes.
+func sniffContentType(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, 512))
+	if err != nil {
+		return "", err
+	}
+	return http.DetectContentType(b), nil
+}
+
+// matchMIMEType reports whether typ matches any of patterns such as text/* and application/json.
+// Parameters of typ like charset are ignored.
+func matchMIMEType(patterns []string, typ string) bool {
+	typ, _, _ = strings.Cut(typ, ";")
+	typ = strings.TrimSpace(typ)
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok {
+			if strings.HasPrefix(typ, prefix+"/") {
+				return true
+			}
+		} else if p == typ {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package gcsunzip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// bqBatchSize and bqFlushInterval bound the rows buffered before streaming them.
+	bqBatchSize     = 500
+	bqFlushInterval = 5 * time.Second
+	// bqAttempts is the maximum number of attempts of each insertAll, which has insert IDs to be deduplicated.
+	bqAttempts = 5
+)
+
+// bqManifest streams a row per uploaded object into a BigQuery table by insertAll.
+type bqManifest struct {
+	svc                     *bigquery.Service
+	project, dataset, table string
+	src                     string
+
+	rows chan map[string]bigquery.JsonValue
+	done chan struct{}
+	once sync.Once
+	// err is the first error of inserts, which is set before done is closed.
+	err error
+}
+
+// parseTable parses [project.]dataset.table.
+func parseTable(s string) (project, dataset, table string, ok bool) {
+	parts := strings.Split(s, ".")
+	for _, p := range parts {
+		if p == "" {
+			return "", "", "", false
+		}
+	}
+	switch len(parts) {
+	case 2:
+		return "", parts[0], parts[1], true
+	case 3:
+		return parts[0], parts[1], parts[2], true
+	}
+	return "", "", "", false
+}
+
+// newBQManifest starts streaming into table, whose project is of the default credentials if omitted.
+func newBQManifest(ctx context.Context, table, src string) (*bqManifest, error) {
+	project, dataset, name, _ := parseTable(table)
+	if project == "" {
+		creds, err := google.FindDefaultCredentials(ctx, bigquery.BigqueryInsertdataScope)
+		if err != nil {
+			return nil, fmt.Errorf("find credentials: %w", err)
+		}
+		if creds.ProjectID == "" {
+			return nil, fmt.Errorf("unknown project of %s", table)
+		}
+		project = creds.ProjectID
+	}
+	svc, err := bigquery.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("bigquery: %w", err)
+	}
+	m := &bqManifest{
+		svc:     svc,
+		project: project,
+		dataset: dataset,
+		table:   name,
+		src:     src,
+		rows:    make(chan map[string]bigquery.JsonValue, bqBatchSize),
+		done:    make(chan struct{}),
+	}
+	// rows of uploaded objects are inserted even if the job is canceled.
+	go m.loop(context.WithoutCancel(ctx))
+	return m, nil
+}
+
+func (m *bqManifest) Add(entry string, attrs *storage.ObjectAttrs) {
+	m.rows <- map[string]bigquery.JsonValue{
+		"object":           "gs://" + attrs.Bucket + "/" + attrs.Name,
+		"entry":            entry,
+		"size":             attrs.Size,
+		"crc32c":           fmt.Sprintf("%08x", attrs.CRC32C),
+		"content_type":     attrs.ContentType,
+		"content_encoding": attrs.ContentEncoding,
+		"source":           m.src,
+		"create_time":      attrs.Created.Format(time.RFC3339Nano),
+		"insert_time":      time.Now().Format(time.RFC3339Nano),
+		"generation":       attrs.Generation,
+	}
+}
+
+// Close flushes the buffered rows and returns the first error of inserts.
+func (m *bqManifest) Close() error {
+	m.once.Do(func() { close(m.rows) })
+	<-m.done
+	return m.err
+}
+
+func (m *bqManifest) loop(ctx context.Context) {
+	defer close(m.done)
+	ticker := time.NewTicker(bqFlushInterval)
+	defer ticker.Stop()
+	var batch []*bigquery.TableDataInsertAllRequestRows
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// rows after an error are dropped, and the job fails with it.
+		if m.err == nil {
+			m.err = m.insert(ctx, batch)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case row, ok := <-m.rows:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, &bigquery.TableDataInsertAllRequestRows{
+				// the same generation of an object is the same row.
+				InsertId: fmt.Sprintf("%s#%d", row["object"], row["generation"]),
+				Json:     row,
+			})
+			if len(batch) >= bqBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (m *bqManifest) insert(ctx context.Context, rows []*bigquery.TableDataInsertAllRequestRows) error {
+	bo := gax.Backoff{Initial: time.Second, Max: 30 * time.Second}
+	for attempt := 1; ; attempt++ {
+		ictx, cancel := context.WithTimeout(ctx, time.Minute)
+		res, err := m.svc.Tabledata.InsertAll(m.project, m.dataset, m.table, &bigquery.TableDataInsertAllRequest{Rows: rows}).Context(ictx).Do()
+		cancel()
+		if err == nil {
+			if len(res.InsertErrors) > 0 && len(res.InsertErrors[0].Errors) > 0 {
+				e := res.InsertErrors[0]
+				return fmt.Errorf("insert row %d: %s", e.Index, e.Errors[0].Message)
+			}
+			debugf("inserted %d rows into %s.%s.%s", len(rows), m.project, m.dataset, m.table)
+			return nil
+		}
+		var gerr *googleapi.Error
+		if attempt == bqAttempts || !errors.As(err, &gerr) || (gerr.Code != http.StatusTooManyRequests && gerr.Code < 500) {
+			return err
+		}
+		debugf("retrying to insert rows: %v", err)
+		if err := gax.Sleep(ctx, bo.Pause()); err != nil {
+			return err
+		}
+	}
+}
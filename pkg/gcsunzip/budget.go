@@ -0,0 +1,146 @@
+package gcsunzip
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Budget is the disk, memory and upload concurrency shared by concurrent jobs which have it in Config.Budget,
+// in addition to DiskLimit and Concurrency of each job. Downloaded archives are not counted in the disk.
+type Budget struct {
+	disk       *semaphore.Weighted
+	diskSize   int64
+	memory     *semaphore.Weighted
+	memorySize int64
+	uploads    *semaphore.Weighted
+}
+
+// NewBudget returns a budget of disk bytes for extracted files, memory bytes for the chunks of uploads
+// and the number of concurrent uploads, where 0 means unlimited.
+func NewBudget(disk, memory uint64, uploads int) *Budget {
+	b := &Budget{}
+	if disk > 0 {
+		b.disk, b.diskSize = semaphore.NewWeighted(int64(disk)), int64(disk)
+	}
+	if memory > 0 {
+		b.memory, b.memorySize = semaphore.NewWeighted(int64(memory)), int64(memory)
+	}
+	if uploads > 0 {
+		b.uploads = semaphore.NewWeighted(int64(uploads))
+	}
+	return b
+}
+
+// diskLimit returns the smaller of limit and the disk of b.
+func (b *Budget) diskLimit(limit uint64) uint64 {
+	if b == nil || b.disk == nil {
+		return limit
+	}
+	return min(limit, uint64(b.diskSize))
+}
+
+// acquireUpload acquires a slot of uploads and the memory of a chunk.
+func (b *Budget) acquireUpload(ctx context.Context, chunk int64) error {
+	if b == nil {
+		return nil
+	}
+	if b.uploads != nil {
+		if err := b.uploads.Acquire(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if b.memory != nil {
+		if err := b.memory.Acquire(ctx, min(chunk, b.memorySize)); err != nil {
+			if b.uploads != nil {
+				b.uploads.Release(1)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Budget) releaseUpload(chunk int64) {
+	if b == nil {
+		return
+	}
+	if b.memory != nil {
+		b.memory.Release(min(chunk, b.memorySize))
+	}
+	if b.uploads != nil {
+		b.uploads.Release(1)
+	}
+}
+
+// diskSemaphore is the disk limit of a job, which also acquires the shared disk of the budget.
+type diskSemaphore struct {
+	local  *semaphore.Weighted
+	budget *Budget
+	// waited is the total time in Acquire.
+	waited atomic.Int64
+	// held is the size acquired and not released yet, and peak is the maximum of it.
+	held atomic.Int64
+	peak atomic.Int64
+}
+
+func newDiskSemaphore(limit uint64, budget *Budget) *diskSemaphore {
+	if budget != nil && budget.disk == nil {
+		budget = nil
+	}
+	return &diskSemaphore{local: semaphore.NewWeighted(int64(limit)), budget: budget}
+}
+
+func (d *diskSemaphore) Acquire(ctx context.Context, n int64) error {
+	start := time.Now()
+	defer func() { d.waited.Add(int64(time.Since(start))) }()
+	if err := d.local.Acquire(ctx, n); err != nil {
+		return err
+	}
+	if d.budget != nil {
+		if err := d.budget.disk.Acquire(ctx, n); err != nil {
+			d.local.Release(n)
+			return err
+		}
+	}
+	held := d.held.Add(n)
+	for {
+		peak := d.peak.Load()
+		if held <= peak || d.peak.CompareAndSwap(peak, held) {
+			break
+		}
+	}
+	return nil
+}
+
+// Resize changes the size held for a file from n to m, acquiring the difference if it grows,
+// which accounts the actual size of a file whose declared size was not reliable.
+func (d *diskSemaphore) Resize(ctx context.Context, n, m int64) error {
+	if m > n {
+		return d.Acquire(ctx, m-n)
+	}
+	if m < n {
+		d.Release(n - m)
+	}
+	return nil
+}
+
+// Waited returns the total time waiting in Acquire.
+func (d *diskSemaphore) Waited() time.Duration {
+	return time.Duration(d.waited.Load())
+}
+
+// Peak returns the maximum size acquired at once.
+func (d *diskSemaphore) Peak() int64 {
+	return d.peak.Load()
+}
+
+func (d *diskSemaphore) Release(n int64) {
+	d.held.Add(-n)
+	if d.budget != nil {
+		d.budget.disk.Release(n)
+	}
+	d.local.Release(n)
+}
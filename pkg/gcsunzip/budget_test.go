@@ -0,0 +1,75 @@
+package gcsunzip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDiskSemaphoreResize(t *testing.T) {
+	tests := []struct {
+		name string
+		// declared is acquired first, and resized to actual.
+		declared, actual int64
+		budget           *Budget
+	}{
+		{name: "grow", declared: 10, actual: 60},
+		{name: "shrink", declared: 60, actual: 10},
+		{name: "same", declared: 30, actual: 30},
+		{name: "grow with budget", declared: 10, actual: 60, budget: NewBudget(80, 0, 0)},
+		{name: "shrink with budget", declared: 60, actual: 10, budget: NewBudget(80, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			d := newDiskSemaphore(100, tt.budget)
+			if err := d.Acquire(ctx, tt.declared); err != nil {
+				t.Fatal(err)
+			}
+			if err := d.Resize(ctx, tt.declared, tt.actual); err != nil {
+				t.Fatal(err)
+			}
+			if got := d.held.Load(); got != tt.actual {
+				t.Errorf("held = %d, want %d", got, tt.actual)
+			}
+			if got, want := d.Peak(), max(tt.declared, tt.actual); got != want {
+				t.Errorf("Peak() = %d, want %d", got, want)
+			}
+			d.Release(tt.actual)
+			// all of the limit and the budget is released.
+			if !d.local.TryAcquire(100) {
+				t.Error("the disk limit is not released")
+			}
+			if tt.budget != nil && !tt.budget.disk.TryAcquire(80) {
+				t.Error("the disk budget is not released")
+			}
+		})
+	}
+}
+
+func TestDiskSemaphoreResizeWaits(t *testing.T) {
+	// an entry which is larger than declared waits for the disk held by the other files.
+	ctx := context.Background()
+	d := newDiskSemaphore(100, nil)
+	if err := d.Acquire(ctx, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Acquire(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := d.Resize(tctx, 10, 60); err == nil {
+		t.Fatal("Resize() = nil, want the deadline exceeded")
+	}
+	if got := d.held.Load(); got != 60 {
+		t.Errorf("held = %d after a failed Resize, want 60", got)
+	}
+	d.Release(50)
+	if err := d.Resize(ctx, 10, 60); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.held.Load(); got != 60 {
+		t.Errorf("held = %d, want 60", got)
+	}
+}
@@ -0,0 +1,49 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var bytesUnits = []struct {
+	suffix string
+	value  uint64
+}{
+	{"t", 1 * 1024 * 1024 * 1024 * 1024},
+	{"g", 1 * 1024 * 1024 * 1024},
+	{"m", 1 * 1024 * 1024},
+	{"k", 1 * 1024},
+	{"tb", 1 * 1024 * 1024 * 1024 * 1024},
+	{"gb", 1 * 1024 * 1024 * 1024},
+	{"mb", 1 * 1024 * 1024},
+	{"kb", 1 * 1024},
+	{"b", 1},
+	{"", 1},
+}
+
+// FormatBytes formats x with the largest binary unit which divides it, e.g. 512k.
+func FormatBytes(x uint64) string {
+	for _, u := range bytesUnits {
+		if x >= u.value {
+			return strconv.FormatUint(x/u.value, 10) + u.suffix
+		}
+	}
+	return "0"
+}
+
+// ParseBytes parses a size with an optional binary unit such as 16m, 50GB or 1024.
+func ParseBytes(s string) (uint64, error) {
+	x := strings.ToLower(s)
+	for _, u := range bytesUnits {
+		if !strings.HasSuffix(x, u.suffix) {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSuffix(x, u.suffix), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse(%s): %w", s, err)
+		}
+		return v * u.value, nil
+	}
+	panic("unreachable")
+}
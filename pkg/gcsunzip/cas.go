@@ -0,0 +1,60 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// casObjectName returns the object name of the content of the SHA-256 in hex under root by Config.Layout cas,
+// e.g. root/objects/ab/cdef....
+func casObjectName(root, sum string) string {
+	return path.Join(root, "objects", sum[:2], sum[2:])
+}
+
+// casManifestName returns the object name of the manifest of the archive under root by Config.Layout cas.
+func casManifestName(root, archive string) string {
+	return path.Join(root, "manifests", archive+".jsonl")
+}
+
+type casEntry struct {
+	Path       string `json:"path"`
+	SHA256     string `json:"sha256,omitempty"`
+	Size       int64  `json:"size"`
+	Object     string `json:"object,omitempty"`
+	LinkTarget string `json:"link_target,omitempty"`
+}
+
+// casManifest collects the paths and the hashes of the entries by Config.Layout cas, and is written as JSON Lines.
+type casManifest struct {
+	mu      sync.Mutex
+	entries []casEntry
+}
+
+func (m *casManifest) Add(e casEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, e)
+}
+
+func (m *casManifest) Write(ctx context.Context, gcs *storage.Client, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.Slice(m.entries, func(i, j int) bool {
+		return m.entries[i].Path < m.entries[j].Path
+	})
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range m.entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+	return writeLocation(ctx, gcs, dst, b.Bytes(), "application/x-ndjson")
+}
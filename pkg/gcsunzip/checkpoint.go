@@ -0,0 +1,186 @@
+package gcsunzip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// checkpointHeader is the first line of a checkpoint, followed by the quoted source archive and its generation.
+const checkpointHeader = "#gcs-unzip-checkpoint"
+
+// checkpointEntry is an uploaded entry by the CRC32C of its object and the CRC-32 of the entry in hex,
+// which is empty if the archive does not record it.
+type checkpointEntry struct {
+	crc      uint32
+	entryCRC string
+}
+
+// checkpoint records entries which are already uploaded from a generation of the source archive.
+// It is stored as a header line of the archive and lines of "<crc32c> <entry crc32 or -> <quoted name>"
+// in a local file or a GCS object.
+type checkpoint struct {
+	mu         sync.Mutex
+	done       map[string]checkpointEntry
+	src        string
+	generation int64
+	dirty      bool
+
+	// saveMu serializes saves so that an older snapshot never overwrites a newer one.
+	saveMu sync.Mutex
+
+	gcs  *storage.Client
+	path string
+}
+
+func openCheckpoint(ctx context.Context, gcs *storage.Client, s string) (*checkpoint, error) {
+	c := &checkpoint{done: map[string]checkpointEntry{}, gcs: gcs, path: s}
+	r, err := openLocation(ctx, gcs, s)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+	defer r.Close()
+	sc := bufio.NewScanner(r)
+	header := false
+	for sc.Scan() {
+		if !header {
+			src, generation, err := parseCheckpointHeader(sc.Text())
+			if err != nil {
+				return nil, err
+			}
+			c.src, c.generation, header = src, generation, true
+			continue
+		}
+		crc, rest, ok := strings.Cut(sc.Text(), " ")
+		entryCRC, quoted, ok2 := strings.Cut(rest, " ")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("parse checkpoint(%s): invalid line", sc.Text())
+		}
+		v, err := strconv.ParseUint(crc, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parse checkpoint(%s): %w", sc.Text(), err)
+		}
+		name, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("parse checkpoint(%s): %w", sc.Text(), err)
+		}
+		if entryCRC == "-" {
+			entryCRC = ""
+		}
+		c.done[name] = checkpointEntry{crc: uint32(v), entryCRC: entryCRC}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read checkpoint: %w", err)
+	}
+	return c, nil
+}
+
+// parseCheckpointHeader parses the header line of a checkpoint. A file written by an older version has no header.
+func parseCheckpointHeader(line string) (string, int64, error) {
+	rest, ok := strings.CutPrefix(line, checkpointHeader+" ")
+	if !ok {
+		return "", 0, fmt.Errorf("%w: checkpoint has no header of the source archive, which was written by an older version", ErrUsage)
+	}
+	i := strings.LastIndexByte(rest, ' ')
+	if i < 0 {
+		return "", 0, fmt.Errorf("parse checkpoint header(%s): invalid header", line)
+	}
+	src, err := strconv.Unquote(rest[:i])
+	if err != nil {
+		return "", 0, fmt.Errorf("parse checkpoint header(%s): %w", line, err)
+	}
+	generation, err := strconv.ParseInt(rest[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("parse checkpoint header(%s): %w", line, err)
+	}
+	return src, generation, nil
+}
+
+// Bind checks that the checkpoint is of the generation of the source archive, which a new checkpoint records,
+// so that a checkpoint of an archive re-delivered with the same name does not skip its changed entries.
+func (c *checkpoint) Bind(src string, generation int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.src == "" {
+		c.src, c.generation = src, generation
+		return nil
+	}
+	if c.src != src || c.generation != generation {
+		return fmt.Errorf("%w: checkpoint %s is of %s (generation %d), not %s (generation %d)", ErrUsage, c.path, c.src, c.generation, src, generation)
+	}
+	return nil
+}
+
+// Lookup returns the CRC32C of the uploaded object of the entry, if it is recorded with the CRC-32 of the entry.
+// Entries whose CRC-32 is not recorded by the archive are compared only by the generation of the archive.
+func (c *checkpoint) Lookup(name, entryCRC string) (uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.done[name]
+	if !ok || e.entryCRC != entryCRC {
+		return 0, false
+	}
+	return e.crc, true
+}
+
+func (c *checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+func (c *checkpoint) Add(name string, crc uint32, entryCRC string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[name] = checkpointEntry{crc: crc, entryCRC: entryCRC}
+	c.dirty = true
+}
+
+func (c *checkpoint) Flush(ctx context.Context) error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	names := make([]string, 0, len(c.done))
+	for name := range c.done {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %d\n", checkpointHeader, strconv.Quote(c.src), c.generation)
+	for _, name := range names {
+		e := c.done[name]
+		entryCRC := e.entryCRC
+		if entryCRC == "" {
+			entryCRC = "-"
+		}
+		// names are quoted, which can contain newlines.
+		fmt.Fprintf(&b, "%08x %s %s\n", e.crc, entryCRC, strconv.Quote(name))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err := writeLocation(ctx, c.gcs, c.path, b.Bytes(), "text/plain"); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package gcsunzip
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	c, err := openCheckpoint(ctx, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Bind("gs://b/a.zip", 1); err != nil {
+		t.Fatal(err)
+	}
+	c.Add("a/x\ny.txt", 0x12345678, "0000abcd")
+	c.Add("a/z.tar.txt", 0x9abcdef0, "")
+	if err := c.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = openCheckpoint(ctx, nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Bind("gs://b/a.zip", 2); !errors.Is(err, ErrUsage) {
+		t.Errorf("Bind() of another generation = %v, want %v", err, ErrUsage)
+	}
+	if err := c.Bind("gs://b/a.zip", 1); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		entryCRC string
+		crc      uint32
+		ok       bool
+	}{
+		{name: "a/x\ny.txt", entryCRC: "0000abcd", crc: 0x12345678, ok: true},
+		{name: "a/x\ny.txt", entryCRC: "0000abce"},
+		{name: "a/z.tar.txt", crc: 0x9abcdef0, ok: true},
+		{name: "a/missing.txt"},
+	}
+	for _, tt := range tests {
+		crc, ok := c.Lookup(tt.name, tt.entryCRC)
+		if crc != tt.crc || ok != tt.ok {
+			t.Errorf("Lookup(%q, %q) = %08x, %v, want %08x, %v", tt.name, tt.entryCRC, crc, ok, tt.crc, tt.ok)
+		}
+	}
+}
+
+func TestCheckpointWithoutHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	if err := os.WriteFile(path, []byte("12345678 a/x.txt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openCheckpoint(context.Background(), nil, path); !errors.Is(err, ErrUsage) {
+		t.Errorf("openCheckpoint() = %v, want %v", err, ErrUsage)
+	}
+}
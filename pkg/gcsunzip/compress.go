@@ -0,0 +1,66 @@
+package gcsunzip
+
+import (
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder compresses objects for a Content-Encoding.
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// encoderPools are the pools of encoders by Content-Encoding.
+var encoderPools = map[string]*sync.Pool{
+	"br": {
+		New: func() any {
+			return brotli.NewWriter(nil)
+		},
+	},
+	"gzip": {
+		New: func() any {
+			return gzip.NewWriter(io.Discard)
+		},
+	},
+	"zstd": {
+		New: func() any {
+			// uploads are already concurrent, so each encoder uses a single goroutine.
+			w, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				panic(err)
+			}
+			return w
+		},
+	},
+}
+
+// zstdDecoder is a reader of a zstd object, which is not decompressed by GCS unlike gzip.
+type zstdDecoder struct {
+	*zstd.Decoder
+}
+
+func (d zstdDecoder) Close() error {
+	d.Decoder.Close()
+	return nil
+}
+
+// newDecoder returns a reader of the decompressed content of an object with the Content-Encoding.
+// gzip objects are decompressed by the GCS reader.
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdDecoder{d}, nil
+	}
+	return io.NopCloser(r), nil
+}
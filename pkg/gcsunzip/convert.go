@@ -0,0 +1,180 @@
+package gcsunzip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// ConvertConfig configures Convert.
+type ConvertConfig struct {
+	// Src is the gs:// URL of the archive.
+	Src string
+	// Dest is the gs:// URL of the converted archive, whose format is given by the extension as ArchiveConfig.Dest.
+	Dest string
+	// Client is used for GCS requests. A client is created if nil.
+	Client *storage.Client
+	// ChunkSize is the chunk size of the upload of the converted archive (default 16MiB).
+	ChunkSize int
+	// TmpDir is the directory of temporary files of the entries whose sizes are unknown in tar archives.
+	TmpDir string
+	// Encoding is the fallback encoding of entry names which are not valid UTF-8 (default shiftjis).
+	Encoding string
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// EncryptionKey is the AES-256 customer-supplied key of the source and the converted archive.
+	EncryptionKey []byte
+}
+
+// Convert writes the entries of an archive on GCS into another archive on GCS, e.g. from zip to tar.zst.
+// The source is read with range requests and the entries are streamed one by one into the upload,
+// so nothing is written to the disk except the entries whose sizes are unknown in tar archives.
+// Unsafe names such as "../a" are made relative to the root. The archive is not created if any entry fails.
+func Convert(ctx context.Context, cfg ConvertConfig) (ArchiveReport, error) {
+	var report ArchiveReport
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 16 * 1024 * 1024
+	}
+	if cfg.Encoding == "" {
+		cfg.Encoding = "shiftjis"
+	}
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	dest, err := parseGSURL(cfg.Dest)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse dest: %w", ErrUsage, err)
+	}
+	if src.String() == dest.String() {
+		return report, fmt.Errorf("%w: Dest must differ from Src: %s", ErrUsage, cfg.Dest)
+	}
+	format, err := archiveFormatOf(dest.Path)
+	if err != nil {
+		return report, err
+	}
+	nameEncoding, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return report, fmt.Errorf("%w: invalid Encoding: %w", ErrUsage, err)
+	}
+	if cfg.EncryptionKey != nil && len(cfg.EncryptionKey) != 32 {
+		return report, fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+	}
+
+	gcs := cfg.Client
+	if gcs == nil {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return report, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	ra, size, err := openSource(ctx, gcs, src, cfg.EncryptionKey, 0, 0)
+	if err != nil {
+		return report, err
+	}
+	extractor, err := newExtractor(ra, size, src.Path, ExtractorOptions{
+		OldWindows: cfg.OldWindows,
+		Encoding:   nameEncoding,
+	})
+	if err != nil {
+		return report, fmt.Errorf("extractor: %w", err)
+	}
+	var archiveMtime time.Time
+	if r, ok := ra.(*remoteReaderAt); ok {
+		archiveMtime = r.ModTime()
+	}
+
+	// the archive is not created if ctx is canceled before Close.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	o := gcs.Bucket(dest.Hostname()).Object(strings.TrimPrefix(dest.Path, "/"))
+	if cfg.EncryptionKey != nil {
+		o = o.Key(cfg.EncryptionKey)
+	}
+	ow := o.Retryer(storage.WithPolicy(storage.RetryAlways)).NewWriter(ctx)
+	ow.ChunkSize = cfg.ChunkSize
+	ow.ContentType = format.contentType
+	abort := func(err error) (ArchiveReport, error) {
+		cancel()
+		ow.Close()
+		return report, err
+	}
+	aw, err := format.new(ow)
+	if err != nil {
+		return abort(fmt.Errorf("archive writer: %w", err))
+	}
+
+	start := time.Now()
+	for i := 0; i < extractor.Files(); i++ {
+		if err := ctx.Err(); err != nil {
+			return abort(err)
+		}
+		name := extractor.FileName(i)
+		if isUnsafePath(name) {
+			name = rebasePath(name)
+		}
+		name = strings.TrimSuffix(filepath.ToSlash(name), "/")
+		if name == "" || name == "." {
+			continue
+		}
+		mtime := extractor.ModTime(i)
+		if mtime.IsZero() {
+			mtime = archiveMtime
+		}
+		if extractor.IsDir(i) {
+			if err := aw.Mkdir(name, mtime); err != nil {
+				return abort(fmt.Errorf("%s: %w", name, err))
+			}
+			continue
+		}
+		if target := extractor.LinkTarget(i); target != "" {
+			if err := aw.Symlink(name, target, mtime); err != nil {
+				return abort(fmt.Errorf("%s: %w", name, err))
+			}
+			continue
+		}
+		size := int64(extractor.FileSize(i))
+		if !extractor.SizeKnown(i) {
+			size = -1
+		}
+		mode := extractor.Mode(i).Perm()
+		if mode == 0 {
+			mode = 0o644
+		}
+		rc, err := extractor.Open(i)
+		if err != nil {
+			return abort(fmt.Errorf("open entry(%s): %w", name, err))
+		}
+		n, err := writeArchiveFile(aw, format, cfg.TmpDir, name, size, mode, mtime, rc)
+		rc.Close()
+		if err != nil {
+			return abort(fmt.Errorf("%s: %w", name, err))
+		}
+		report.Files++
+		report.Bytes += n
+		debugf("%7d: %s(%s)", report.Files, name, FormatBytes(uint64(n)))
+	}
+	if err := aw.Close(); err != nil {
+		return abort(fmt.Errorf("close archive: %w", err))
+	}
+	if err := ow.Close(); err != nil {
+		return report, fmt.Errorf("close writer: %w", err)
+	}
+	report.Size = ow.Attrs().Size
+	logEvent(slog.LevelInfo, []slog.Attr{
+		slog.String("event", "convert"),
+		slog.String("src", cfg.Src),
+		slog.String("dest", cfg.Dest),
+		slog.Int64("files", report.Files),
+		slog.Int64("bytes", report.Bytes),
+		slog.Int64("size", report.Size),
+		slog.Duration("duration", time.Since(start)),
+	}, "convert: %d files, %s -> %s(%s): %s", report.Files, FormatBytes(uint64(report.Bytes)), cfg.Dest, FormatBytes(uint64(report.Size)), time.Since(start).Round(time.Millisecond))
+	return report, nil
+}
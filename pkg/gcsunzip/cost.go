@@ -0,0 +1,43 @@
+package gcsunzip
+
+import "strings"
+
+// storagePrice is the list price of a storage class in USD.
+type storagePrice struct {
+	// classA is per Class A operation, e.g. an upload of an object.
+	classA float64
+	// storage is per GiB-month.
+	storage float64
+}
+
+// storagePrices are the list prices of the regional locations in North America, which are only estimates
+// for the other locations and do not include discounts, network and the minimum storage durations.
+var storagePrices = map[string]storagePrice{
+	"STANDARD": {classA: 0.005 / 1000, storage: 0.020},
+	"NEARLINE": {classA: 0.010 / 1000, storage: 0.010},
+	"COLDLINE": {classA: 0.020 / 1000, storage: 0.004},
+	"ARCHIVE":  {classA: 0.050 / 1000, storage: 0.0012},
+}
+
+// Cost is an estimate of the Cloud Storage cost of the objects written by a job in USD.
+type Cost struct {
+	// ClassAOps are the uploads of objects, and ClassA is their cost.
+	ClassAOps int64   `json:"class_a_ops"`
+	ClassA    float64 `json:"class_a_usd"`
+	// StorageBytes is the size of the objects, and Storage is their cost per month.
+	StorageBytes int64   `json:"storage_bytes"`
+	Storage      float64 `json:"storage_usd_per_month"`
+}
+
+// add adds the cost of ops uploads of n bytes in total with the storage class, where the default
+// storage class of the bucket is assumed to be STANDARD.
+func (c *Cost) add(class string, ops, n int64) {
+	p, ok := storagePrices[strings.ToUpper(class)]
+	if !ok {
+		p = storagePrices["STANDARD"]
+	}
+	c.ClassAOps += ops
+	c.ClassA += float64(ops) * p.classA
+	c.StorageBytes += n
+	c.Storage += float64(n) / (1 << 30) * p.storage
+}
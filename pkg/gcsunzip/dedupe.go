@@ -0,0 +1,24 @@
+package gcsunzip
+
+import "cloud.google.com/go/storage"
+
+// dedupeKey identifies the entries which are identical by Config.Dedupe. The compressed size is also compared
+// to reduce the collisions of CRC-32, because identical contents are compressed alike in an archive.
+type dedupeKey struct {
+	crc            uint32
+	size           uint64
+	compressedSize uint64
+}
+
+// dedupeSource is the first of the identical entries, whose object is copied to the others after its upload.
+type dedupeSource struct {
+	entry string
+	// done is closed when the upload finishes.
+	done chan struct{}
+	// attrs is the uploaded object, which is nil if the upload failed.
+	attrs *storage.ObjectAttrs
+}
+
+func newDedupeSource(entry string) *dedupeSource {
+	return &dedupeSource{entry: entry, done: make(chan struct{})}
+}
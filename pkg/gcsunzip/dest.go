@@ -0,0 +1,68 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// destTemplate expands the destination path into an object name per entry.
+// It can contain {archive} (the archive name without its extension), {date} (the job start date in UTC),
+// {entry} (the entry name under the archive root) and {ext} (the entry extension without the dot).
+// A path without {entry} is followed by "/{archive}/{entry}", or "/{entry}" if archivePrefix is false.
+type destTemplate struct {
+	tmpl string
+	vars map[string]string
+}
+
+var destVars = []string{"archive", "date", "entry", "ext"}
+
+func newDestTemplate(p, archive string, now time.Time, archivePrefix bool) (*destTemplate, error) {
+	if !strings.Contains(p, "{entry}") {
+		if archivePrefix {
+			p = path.Join(p, "{archive}", "{entry}")
+		} else {
+			p = path.Join(p, "{entry}")
+		}
+	}
+	s := p
+	for _, v := range destVars {
+		s = strings.ReplaceAll(s, "{"+v+"}", "")
+	}
+	if i := strings.IndexAny(s, "{}"); i >= 0 {
+		return nil, fmt.Errorf("unknown variable in %s", p)
+	}
+	return &destTemplate{
+		tmpl: p,
+		vars: map[string]string{
+			"archive": archive,
+			"date":    now.UTC().Format("2006-01-02"),
+		},
+	}, nil
+}
+
+// Object returns the object name of the slash-separated entry name.
+func (t *destTemplate) Object(entry string) string {
+	ext := strings.TrimPrefix(path.Ext(entry), ".")
+	s := strings.NewReplacer(
+		"{archive}", t.vars["archive"],
+		"{date}", t.vars["date"],
+		"{entry}", entry,
+		"{ext}", ext,
+	).Replace(t.tmpl)
+	return strings.TrimPrefix(path.Clean("/"+s), "/")
+}
+
+// Prefix returns the longest object name prefix which is common to all entries, ending with a slash if not empty.
+func (t *destTemplate) Prefix() string {
+	s := t.tmpl
+	if i := strings.Index(s, "{entry}"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.Index(s, "{ext}"); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.NewReplacer("{archive}", t.vars["archive"], "{date}", t.vars["date"]).Replace(s)
+	return s[:strings.LastIndex(s, "/")+1]
+}
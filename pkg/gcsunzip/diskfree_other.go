@@ -0,0 +1,8 @@
+//go:build !(linux || darwin)
+
+package gcsunzip
+
+// diskFree returns the available bytes of the filesystem containing dir.
+func diskFree(dir string) (uint64, bool) {
+	return 0, false
+}
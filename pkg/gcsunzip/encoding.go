@@ -0,0 +1,133 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+var encodings = map[string]encoding.Encoding{
+	"shiftjis": japanese.ShiftJIS,
+	"sjis":     japanese.ShiftJIS,
+	"cp932":    japanese.ShiftJIS,
+	"eucjp":    japanese.EUCJP,
+	"cp437":    charmap.CodePage437,
+	"cp850":    charmap.CodePage850,
+	"cp866":    charmap.CodePage866,
+	"cp1251":   charmap.Windows1251,
+	"cp1252":   charmap.Windows1252,
+	"gbk":      simplifiedchinese.GBK,
+	"cp936":    simplifiedchinese.GBK,
+	"gb18030":  simplifiedchinese.GB18030,
+	"big5":     traditionalchinese.Big5,
+	"cp950":    traditionalchinese.Big5,
+	"euckr":    korean.EUCKR,
+	"cp949":    korean.EUCKR,
+}
+
+// lookupEncoding returns the encoding by a short name like "cp437", or by an IANA name.
+// It returns nil for "auto".
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	if name == "auto" {
+		return nil, nil
+	}
+	key := strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(name))
+	if e, ok := encodings[key]; ok {
+		return e, nil
+	}
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("unknown encoding: %s", name)
+	}
+	return e, nil
+}
+
+type encodingCandidate struct {
+	enc encoding.Encoding
+	// common reports whether the encoded character is frequently used in the language.
+	common func(b []byte) bool
+	// alphabetic means that the letters of the language are not mixed with ASCII letters in a word.
+	alphabetic bool
+}
+
+// autoCandidates are tried in order, the former wins on a tie.
+// Stricter candidates come first since the ranges of the CJK encodings overlap.
+var autoCandidates = []encodingCandidate{
+	// kana and JIS level 1 kanji
+	{enc: japanese.ShiftJIS, common: func(b []byte) bool {
+		return len(b) == 2 && (0x82 <= b[0] && b[0] <= 0x83 || 0x88 <= b[0] && b[0] <= 0x98)
+	}},
+	// KS X 1001 hangul
+	{enc: korean.EUCKR, common: func(b []byte) bool { return len(b) == 2 && 0xb0 <= b[0] && b[0] <= 0xc8 }},
+	// GB2312 level 1 hanzi
+	{enc: simplifiedchinese.GBK, common: func(b []byte) bool { return len(b) == 2 && 0xb0 <= b[0] && b[0] <= 0xd7 }},
+	// Big5 frequently used hanzi
+	{enc: traditionalchinese.Big5, common: func(b []byte) bool { return len(b) == 2 && 0xa4 <= b[0] && b[0] <= 0xc6 }},
+	// accented latin letters
+	{enc: charmap.CodePage437, common: func(b []byte) bool { return len(b) == 1 && 0x80 <= b[0] && b[0] <= 0xa5 }},
+	// cyrillic letters
+	{enc: charmap.CodePage866, common: func(b []byte) bool {
+		return len(b) == 1 && (0x80 <= b[0] && b[0] <= 0xaf || 0xe0 <= b[0] && b[0] <= 0xf1)
+	}, alphabetic: true},
+}
+
+// detectEncoding guesses the legacy encoding of names which are not valid UTF-8.
+// Each candidate is scored by how many bytes are decoded into frequently used characters.
+func detectEncoding(names []string) encoding.Encoding {
+	best := autoCandidates[0].enc
+	bestScore := 0
+	found := false
+	for _, c := range autoCandidates {
+		score, ok := scoreEncoding(c, names)
+		if ok && (!found || score > bestScore) {
+			best, bestScore, found = c.enc, score, true
+		}
+	}
+	return best
+}
+
+func scoreEncoding(c encodingCandidate, names []string) (int, bool) {
+	dec := c.enc.NewDecoder()
+	enc := c.enc.NewEncoder()
+	score := 0
+	for _, name := range names {
+		if utf8.ValidString(name) {
+			continue
+		}
+		d, err := dec.String(name)
+		if err != nil {
+			return 0, false
+		}
+		rs := []rune(d)
+		for i, r := range rs {
+			if r < utf8.RuneSelf {
+				continue
+			}
+			if r == utf8.RuneError {
+				return 0, false
+			}
+			b, err := enc.Bytes([]byte(string(r)))
+			if err != nil {
+				return 0, false
+			}
+			if !c.common(b) || c.alphabetic && (i > 0 && isASCIILetter(rs[i-1]) || i+1 < len(rs) && isASCIILetter(rs[i+1])) {
+				score -= 2 * len(b)
+			} else {
+				score += len(b)
+			}
+		}
+	}
+	return score, true
+}
+
+func isASCIILetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
@@ -0,0 +1,27 @@
+package gcsunzip
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUsage is returned for an invalid Config.
+	ErrUsage = errors.New("usage")
+	// ErrPartialFailure is returned when some entries failed with Config.ContinueOnError.
+	ErrPartialFailure = errors.New("some entries failed")
+	// ErrDeadlineExceeded is the cause of the cancellation when the job exceeds its deadline.
+	ErrDeadlineExceeded = errors.New("deadline exceeded")
+	// ErrInterrupted is returned when the job is stopped by Config.Stop.
+	ErrInterrupted = errors.New("interrupted")
+	// ErrSourceNotFound is returned when the archive object does not exist.
+	ErrSourceNotFound = errors.New("source not found")
+	// ErrUnsupportedFormat is returned for an archive of an unknown extension,
+	// or for an entry which cannot be extracted, such as a placeholder size of an unknown method.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrNoSpace is returned when an entry does not fit in the disk limit or the free space of the temporary directory.
+	ErrNoSpace = errors.New("no enough space")
+	// ErrUpload wraps the first error of the uploads, which stops the job.
+	ErrUpload = errors.New("upload failed")
+	// ErrVerify is returned when the uploaded objects differ from the entries with Config.Verify, and by Verify.
+	ErrVerify = errors.New("verification failed")
+)
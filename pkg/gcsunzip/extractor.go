@@ -0,0 +1,322 @@
+package gcsunzip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/zip"
+	"golang.org/x/text/encoding"
+)
+
+type Extractor interface {
+	Files() int
+	FileName(int) string
+	// ValidName reports whether the raw name is valid UTF-8 or decodable with the fallback encoding.
+	ValidName(int) bool
+	FileSize(int) uint64
+	// SizeKnown reports whether FileSize is reliable.
+	// It is false for zip entries whose sizes are placeholders without Zip64 extra fields, except stored ones.
+	SizeKnown(int) bool
+	// CompressedSize returns 0 if it is unknown. e.g. entries in a solid 7z block.
+	CompressedSize(int) uint64
+	IsDir(int) bool
+	Mode(int) fs.FileMode
+	// ModTime returns the modification time of the entry, which is zero if it is not recorded.
+	ModTime(int) time.Time
+	// LinkTarget returns the target of the symlink entry, or "" if it is not a symlink or unreadable.
+	LinkTarget(int) string
+	Open(int) (io.ReadCloser, error)
+}
+
+// ExtractorOptions configures how entry names are decoded.
+type ExtractorOptions struct {
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// Encoding decodes names which are not valid UTF-8. It is detected from the names if nil.
+	Encoding encoding.Encoding
+	// HexUndecodable percent-encodes non-ASCII bytes of undecodable names
+	// instead of replacing them with U+FFFD.
+	HexUndecodable bool
+}
+
+func NewExtractor(f *os.File, opts ExtractorOptions) (Extractor, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat: %w", err)
+	}
+	return newExtractor(f, fi.Size(), f.Name(), opts)
+}
+
+type zipExtractor struct {
+	zr   *zip.Reader
+	ra   io.ReaderAt
+	size int64
+	opts ExtractorOptions
+}
+
+// zipFlagUTF8 is the language encoding flag (EFS) which means the name is UTF-8.
+const zipFlagUTF8 = 0x800
+
+// zipExtraUnicodePath is the Info-ZIP Unicode Path extra field.
+const zipExtraUnicodePath = 0x7075
+
+// zip32Max is the placeholder of sizes which are stored in the Zip64 extra field.
+const zip32Max = 0xffffffff
+
+// zipExtraZip64 is the Zip64 extended information extra field.
+const zipExtraZip64 = 0x0001
+
+// zipFlagDataDescriptor means the sizes and the CRC-32 follow the data, written by streaming tools.
+const zipFlagDataDescriptor = 0x8
+
+func (e *zipExtractor) Files() int {
+	return len(e.zr.File)
+}
+
+func (e *zipExtractor) FileName(i int) string {
+	name, ok := e.decodeName(i)
+	if !ok {
+		name = undecodableName(e.zr.File[i].Name, name, e.opts.HexUndecodable)
+	}
+	// old Windows tools use backslashes as separators, which is assumed for names without slashes.
+	if e.opts.OldWindows || strings.Contains(name, `\`) && !strings.Contains(name, "/") {
+		name = strings.ReplaceAll(name, "\\", "/")
+	}
+	return filepath.FromSlash(name)
+}
+
+func (e *zipExtractor) ValidName(i int) bool {
+	_, ok := e.decodeName(i)
+	return ok
+}
+
+// decodeName prefers the Unicode Path extra field and the EFS flag to the fallback encoding.
+func (e *zipExtractor) decodeName(i int) (string, bool) {
+	f := e.zr.File[i]
+	if name, ok := unicodePath(f); ok {
+		return name, true
+	}
+	if f.Flags&zipFlagUTF8 != 0 {
+		return f.Name, utf8.ValidString(f.Name)
+	}
+	return decodeName(f.Name, e.opts.Encoding)
+}
+
+// unicodePath returns the UTF-8 name in the Info-ZIP Unicode Path extra field
+// if it is present and its CRC matches the raw name.
+func unicodePath(f *zip.File) (string, bool) {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			break
+		}
+		data := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraUnicodePath || len(data) < 5 || data[0] != 1 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(data[1:5]) != crc32.ChecksumIEEE([]byte(f.Name)) {
+			continue
+		}
+		name := string(data[5:])
+		if !utf8.ValidString(name) {
+			continue
+		}
+		return name, true
+	}
+	return "", false
+}
+
+// placeholderSize reports whether the uncompressed size of the entry is the placeholder of a streaming tool,
+// which wrote it to the central directory without a Zip64 extra field for an entry with a data descriptor.
+// The size without a data descriptor is genuine, and a compressed size placeholder is rejected by zip.NewReader.
+func (e *zipExtractor) placeholderSize(i int) bool {
+	f := e.zr.File[i]
+	return f.UncompressedSize64 == zip32Max && f.Flags&zipFlagDataDescriptor != 0 && !hasExtra(f, zipExtraZip64)
+}
+
+func (e *zipExtractor) FileSize(i int) uint64 {
+	f := e.zr.File[i]
+	if f.Method == zip.Store && e.placeholderSize(i) {
+		// the data of a stored entry is as is.
+		return f.CompressedSize64
+	}
+	return f.UncompressedSize64
+}
+
+func (e *zipExtractor) SizeKnown(i int) bool {
+	return !e.placeholderSize(i) || e.zr.File[i].Method == zip.Store
+}
+
+func (e *zipExtractor) CompressedSize(i int) uint64 {
+	return e.zr.File[i].CompressedSize64
+}
+
+func (e *zipExtractor) IsDir(i int) bool {
+	return e.zr.File[i].Mode()&fs.ModeDir != 0
+}
+
+func (e *zipExtractor) Mode(i int) fs.FileMode {
+	return e.zr.File[i].Mode()
+}
+
+func (e *zipExtractor) ModTime(i int) time.Time {
+	return e.zr.File[i].Modified
+}
+
+func (e *zipExtractor) LinkTarget(i int) string {
+	return linkTarget(e, i)
+}
+
+func (e *zipExtractor) Open(i int) (io.ReadCloser, error) {
+	f := e.zr.File[i]
+	if !e.placeholderSize(i) {
+		return f.Open()
+	}
+	// the size is a placeholder without Zip64 extra fields (written by some broken tools), which f.Open trusts,
+	// so the data is read by its compressed size or until the end of the deflate stream instead.
+	off, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	switch f.Method {
+	case zip.Store:
+		r := io.NewSectionReader(e.ra, off, int64(f.CompressedSize64))
+		return &checksumReader{rc: io.NopCloser(r), h: crc32.NewIEEE(), want: f.CRC32}, nil
+	case zip.Deflate:
+		fr := flate.NewReader(io.NewSectionReader(e.ra, off, e.size-off))
+		return &checksumReader{rc: fr, h: crc32.NewIEEE(), want: f.CRC32}, nil
+	}
+	return nil, fmt.Errorf("%w: the size of %s is a placeholder", ErrUnsupportedFormat, zipMethodName(f.Method))
+}
+
+// hasExtra reports whether the entry has the extra field of the id.
+func hasExtra(f *zip.File, id uint16) bool {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if binary.LittleEndian.Uint16(extra[0:2]) == id {
+			return true
+		}
+		if len(extra) < 4+size {
+			break
+		}
+		extra = extra[4+size:]
+	}
+	return false
+}
+
+type checksumReader struct {
+	rc   io.ReadCloser
+	h    hash.Hash32
+	want uint32
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && r.h.Sum32() != r.want {
+		return n, zip.ErrChecksum
+	}
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	return r.rc.Close()
+}
+
+type sevenZipExtractor struct {
+	zr   *sevenzip.Reader
+	opts ExtractorOptions
+}
+
+func (e *sevenZipExtractor) Files() int {
+	return len(e.zr.File)
+}
+
+func (e *sevenZipExtractor) FileName(i int) string {
+	name, ok := decodeName(e.zr.File[i].Name, e.opts.Encoding)
+	if !ok {
+		name = undecodableName(e.zr.File[i].Name, name, e.opts.HexUndecodable)
+	}
+	return filepath.FromSlash(name)
+}
+
+func (e *sevenZipExtractor) ValidName(i int) bool {
+	_, ok := decodeName(e.zr.File[i].Name, e.opts.Encoding)
+	return ok
+}
+
+func (e *sevenZipExtractor) FileSize(i int) uint64 {
+	return e.zr.File[i].UncompressedSize
+}
+
+func (e *sevenZipExtractor) SizeKnown(i int) bool {
+	return true
+}
+
+func (e *sevenZipExtractor) CompressedSize(i int) uint64 {
+	return 0
+}
+
+func (e *sevenZipExtractor) IsDir(i int) bool {
+	return e.zr.File[i].Mode()&fs.ModeDir != 0
+}
+
+func (e *sevenZipExtractor) Mode(i int) fs.FileMode {
+	return e.zr.File[i].Mode()
+}
+
+func (e *sevenZipExtractor) ModTime(i int) time.Time {
+	return e.zr.File[i].Modified
+}
+
+func (e *sevenZipExtractor) LinkTarget(i int) string {
+	return linkTarget(e, i)
+}
+
+func (e *sevenZipExtractor) Open(i int) (io.ReadCloser, error) {
+	return e.zr.File[i].Open()
+}
+
+// decodeName decodes s with enc if it is not valid UTF-8.
+// It reports false if s could not be decoded without replacement characters.
+func decodeName(s string, enc encoding.Encoding) (string, bool) {
+	if utf8.ValidString(s) {
+		return s, true
+	}
+	d, err := enc.NewDecoder().String(s)
+	if err != nil {
+		return s, false
+	}
+	return d, !strings.ContainsRune(d, utf8.RuneError)
+}
+
+// undecodableName makes a valid UTF-8 name from the raw name which could not be decoded.
+func undecodableName(raw, decoded string, hex bool) string {
+	if !hex {
+		return strings.ToValidUTF8(decoded, string(utf8.RuneError))
+	}
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if c := raw[i]; c < utf8.RuneSelf && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
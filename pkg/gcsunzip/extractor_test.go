@@ -0,0 +1,330 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+)
+
+// zipEntry is an entry of a synthetic zip built by buildZip.
+type zipEntry struct {
+	name   string
+	method uint16
+	data   []byte
+	// raw writes the entry without a data descriptor by CreateRaw.
+	raw bool
+}
+
+func buildZip(t *testing.T, entries []zipEntry) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, e := range entries {
+		var w io.Writer
+		var err error
+		if e.raw {
+			w, err = zw.CreateRaw(&zip.FileHeader{
+				Name:               e.name,
+				Method:             zip.Store,
+				CRC32:              crc32.ChecksumIEEE(e.data),
+				CompressedSize64:   uint64(len(e.data)),
+				UncompressedSize64: uint64(len(e.data)),
+			})
+		} else {
+			w, err = zw.CreateHeader(&zip.FileHeader{Name: e.name, Method: e.method})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// patchCentral modifies the central directory header of the i-th entry, as broken tools write it.
+func patchCentral(t *testing.T, b []byte, i int, patch func(h []byte)) {
+	t.Helper()
+	off := 0
+	for n := 0; ; n++ {
+		j := bytes.Index(b[off:], []byte("PK\x01\x02"))
+		if j < 0 {
+			t.Fatalf("central directory header %d not found", i)
+		}
+		off += j
+		if n == i {
+			patch(b[off : off+46])
+			return
+		}
+		off += 4
+	}
+}
+
+// placeholderSize sets the uncompressed size of the header to the placeholder without a Zip64 extra field.
+func placeholderSize(h []byte) {
+	binary.LittleEndian.PutUint32(h[24:28], zip32Max)
+}
+
+// badCRC corrupts the CRC-32 of the header.
+func badCRC(h []byte) {
+	binary.LittleEndian.PutUint32(h[16:20], binary.LittleEndian.Uint32(h[16:20])^1)
+}
+
+func openTestZip(t *testing.T, b []byte) *zipExtractor {
+	t.Helper()
+	e, err := newExtractor(bytes.NewReader(b), int64(len(b)), "test.zip", ExtractorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.(*zipExtractor)
+}
+
+func TestZipExtractorPlaceholderSize(t *testing.T) {
+	data := bytes.Repeat([]byte("gcs-unzip "), 1000)
+	tests := []struct {
+		name      string
+		entry     zipEntry
+		patch     []func(h []byte)
+		sizeKnown bool
+		size      uint64
+		wantErr   error
+	}{
+		{
+			name:      "deflate",
+			entry:     zipEntry{name: "a.txt", method: zip.Deflate, data: data},
+			sizeKnown: true,
+			size:      uint64(len(data)),
+		},
+		{
+			name:      "deflate placeholder",
+			entry:     zipEntry{name: "a.txt", method: zip.Deflate, data: data},
+			patch:     []func(h []byte){placeholderSize},
+			sizeKnown: false,
+			size:      zip32Max,
+		},
+		{
+			name:    "deflate placeholder crc mismatch",
+			entry:   zipEntry{name: "a.txt", method: zip.Deflate, data: data},
+			patch:   []func(h []byte){placeholderSize, badCRC},
+			size:    zip32Max,
+			wantErr: zip.ErrChecksum,
+		},
+		{
+			name:      "store placeholder",
+			entry:     zipEntry{name: "a.txt", method: zip.Store, data: data},
+			patch:     []func(h []byte){placeholderSize},
+			sizeKnown: true,
+			size:      uint64(len(data)),
+		},
+		{
+			name:      "store placeholder crc mismatch",
+			entry:     zipEntry{name: "a.txt", method: zip.Store, data: data},
+			patch:     []func(h []byte){placeholderSize, badCRC},
+			sizeKnown: true,
+			size:      uint64(len(data)),
+			wantErr:   zip.ErrChecksum,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := buildZip(t, []zipEntry{tt.entry})
+			for _, p := range tt.patch {
+				patchCentral(t, b, 0, p)
+			}
+			e := openTestZip(t, b)
+			if got := e.SizeKnown(0); got != tt.sizeKnown {
+				t.Errorf("SizeKnown() = %v, want %v", got, tt.sizeKnown)
+			}
+			if got := e.FileSize(0); got != tt.size {
+				t.Errorf("FileSize() = %d, want %d", got, tt.size)
+			}
+			rc, err := e.Open(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ReadAll() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("ReadAll() = %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestZipExtractorGenuineMaxSize(t *testing.T) {
+	// an entry without a data descriptor can have the size 0xffffffff without a Zip64 extra field.
+	b := buildZip(t, []zipEntry{{name: "a.bin", data: []byte("data"), raw: true}})
+	patchCentral(t, b, 0, placeholderSize)
+	e := openTestZip(t, b)
+	if !e.SizeKnown(0) {
+		t.Error("SizeKnown() = false, want true")
+	}
+	if got := e.FileSize(0); got != zip32Max {
+		t.Errorf("FileSize() = %d, want %d", got, uint64(zip32Max))
+	}
+}
+
+func TestZipExtractorUnsupportedPlaceholder(t *testing.T) {
+	b := buildZip(t, []zipEntry{{name: "a.txt", method: zip.Deflate, data: []byte("data")}})
+	patchCentral(t, b, 0, func(h []byte) {
+		placeholderSize(h)
+		binary.LittleEndian.PutUint16(h[10:12], 12)
+	})
+	e := openTestZip(t, b)
+	if _, err := e.Open(0); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Open() error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestZipExtractorZip64(t *testing.T) {
+	t.Run("declared over 4GB", func(t *testing.T) {
+		var b bytes.Buffer
+		zw := zip.NewWriter(&b)
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               "big.bin",
+			Method:             zip.Deflate,
+			CompressedSize64:   4,
+			UncompressedSize64: 5 << 30,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("data"))
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		e := openTestZip(t, b.Bytes())
+		if !hasExtra(e.zr.File[0], zipExtraZip64) {
+			t.Fatal("no Zip64 extra field")
+		}
+		if !e.SizeKnown(0) {
+			t.Error("SizeKnown() = false, want true")
+		}
+		if got := e.FileSize(0); got != 5<<30 {
+			t.Errorf("FileSize() = %d, want %d", got, uint64(5<<30))
+		}
+	})
+	t.Run("more than 65535 entries", func(t *testing.T) {
+		const n = 70000
+		entries := make([]zipEntry, n)
+		for i := range entries {
+			entries[i] = zipEntry{name: fmt.Sprintf("%05d.txt", i), method: zip.Store}
+		}
+		e := openTestZip(t, buildZip(t, entries))
+		if got := e.Files(); got != n {
+			t.Fatalf("Files() = %d, want %d", got, n)
+		}
+		if got, want := e.FileName(n-1), fmt.Sprintf("%05d.txt", n-1); got != want {
+			t.Errorf("FileName(%d) = %q, want %q", n-1, got, want)
+		}
+	})
+	t.Run("streamed over 4GB", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("writes 4GB")
+		}
+		const size = 4<<30 + 1
+		var b bytes.Buffer
+		zw := zip.NewWriter(&b)
+		w, err := zw.Create("big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.CopyN(w, zeroReader{}, size); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		e := openTestZip(t, b.Bytes())
+		if !e.SizeKnown(0) || e.FileSize(0) != size {
+			t.Fatalf("SizeKnown() = %v, FileSize() = %d, want true, %d", e.SizeKnown(0), e.FileSize(0), uint64(size))
+		}
+		rc, err := e.Open(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		n, err := io.Copy(io.Discard, rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != size {
+			t.Errorf("read %d bytes, want %d", n, int64(size))
+		}
+	})
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestWriteTemporaryPlaceholderSize(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	tests := []struct {
+		name   string
+		method uint16
+		limit  int64
+		want   int64
+		err    error
+	}{
+		{name: "deflate", method: zip.Deflate, limit: -1, want: int64(len(data))},
+		{name: "store", method: zip.Store, limit: -1, want: int64(len(data))},
+		{name: "deflate over limit", method: zip.Deflate, limit: 100, err: errTotalSizeExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := buildZip(t, []zipEntry{{name: "a.txt", method: tt.method, data: data}})
+			patchCentral(t, b, 0, placeholderSize)
+			e := openTestZip(t, b)
+			dir := t.TempDir()
+			n, crc, err := writeTemporary(context.Background(), e, 0, "a.txt", dir, tt.limit)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("writeTemporary() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("writeTemporary() = %d bytes, want %d", n, tt.want)
+			}
+			if want := crc32.Checksum(data, crc32cTable); crc != want {
+				t.Errorf("crc32c = %08x, want %08x", crc, want)
+			}
+			got, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("file = %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+type failure struct {
+	Entry string `json:"entry"`
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// failures collects per-entry errors and is written as JSON Lines.
+type failures struct {
+	mu   sync.Mutex
+	list []failure
+}
+
+func (f *failures) Add(entry, stage string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.list = append(f.list, failure{Entry: entry, Stage: stage, Error: err.Error()})
+}
+
+func (f *failures) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.list)
+}
+
+func (f *failures) Write(ctx context.Context, gcs *storage.Client, dst string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, x := range f.list {
+		if err := enc.Encode(x); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+	return writeLocation(ctx, gcs, dst, b.Bytes(), "application/x-ndjson")
+}
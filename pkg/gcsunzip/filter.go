@@ -0,0 +1,83 @@
+package gcsunzip
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// entryFilter selects entries by their slash-separated names in the archive.
+// An entry is included if it matches any glob or regexp of the includes, and excludes are evaluated after them.
+// only are the globs of Config.Only, which an entry has to match in addition to the includes.
+type entryFilter struct {
+	only      []string
+	include   []string
+	exclude   []string
+	includeRe []*regexp.Regexp
+	excludeRe []*regexp.Regexp
+}
+
+func (f *entryFilter) Match(name string) bool {
+	if len(f.only) > 0 && !matchAny(f.only, nil, name) {
+		return false
+	}
+	if len(f.include)+len(f.includeRe) > 0 && !matchAny(f.include, f.includeRe, name) {
+		return false
+	}
+	return !matchAny(f.exclude, f.excludeRe, name)
+}
+
+func matchAny(globs []string, res []*regexp.Regexp, name string) bool {
+	for _, p := range globs {
+		if matchGlob(p, name) {
+			return true
+		}
+	}
+	for _, re := range res {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validGlob reports whether the pattern is well-formed.
+func validGlob(pattern string) bool {
+	for _, seg := range strings.Split(pattern, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob reports whether the name matches the pattern.
+// "**" matches zero or more path segments, and a pattern without slashes matches the base name.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(ps, ns []string) bool {
+	for len(ps) > 0 {
+		if ps[0] == "**" {
+			for i := len(ns); i >= 0; i-- {
+				if matchSegments(ps[1:], ns[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(ns) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(ps[0], ns[0]); !ok {
+			return false
+		}
+		ps, ns = ps[1:], ns[1:]
+	}
+	return len(ns) == 0
+}
@@ -0,0 +1,123 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/klauspost/compress/zip"
+)
+
+// probeSize is the size of the header passed to probes.
+const probeSize = 512
+
+// Opener opens an archive of size bytes read from ra. opts.Encoding is nil if it should be detected from the names.
+type Opener func(ra io.ReaderAt, size int64, opts ExtractorOptions) (Extractor, error)
+
+type format struct {
+	probe func(header []byte) bool
+	open  Opener
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string][]format{}
+)
+
+func init() {
+	RegisterFormat(".zip", nil, openZip)
+	RegisterFormat(".7z", nil, openSevenZip)
+}
+
+// RegisterFormat registers an archive format of the extension, e.g. ".rar", which is case-insensitive.
+// When multiple formats are registered for an extension, the last one whose probe reports true for the
+// first bytes of the archive is used, and a nil probe always matches, so a format can be registered for
+// ".zip" to handle a variant and fall back to the built-in one. It is usually called in init.
+func RegisterFormat(ext string, probe func(header []byte) bool, open Opener) {
+	ext = normalizeFormatExt(ext)
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[ext] = append(formats[ext], format{probe: probe, open: open})
+}
+
+func normalizeFormatExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// SupportedFormat reports whether a format is registered for the extension of the archive name.
+func SupportedFormat(name string) bool {
+	return formatsOf(path.Ext(name)) != nil
+}
+
+// formatsOf returns the formats of the extension, which are nil if it is unsupported.
+func formatsOf(ext string) []format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	return formats[normalizeFormatExt(ext)]
+}
+
+// newExtractor opens the archive of the format given by the extension of name.
+func newExtractor(ra io.ReaderAt, size int64, name string, opts ExtractorOptions) (Extractor, error) {
+	candidates := formatsOf(filepath.Ext(name))
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, filepath.Ext(name))
+	}
+	var header []byte
+	for i := len(candidates) - 1; i >= 0; i-- {
+		if p := candidates[i].probe; p != nil {
+			if header == nil {
+				header = make([]byte, min(size, probeSize))
+				n, err := ra.ReadAt(header, 0)
+				if err != nil && err != io.EOF {
+					return nil, fmt.Errorf("read header: %w", err)
+				}
+				header = header[:n]
+			}
+			if !p(header) {
+				continue
+			}
+		}
+		return candidates[i].open(ra, size, opts)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, filepath.Ext(name))
+}
+
+func openZip(ra io.ReaderAt, size int64, opts ExtractorOptions) (Extractor, error) {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, fmt.Errorf("zip: %w", err)
+	}
+	if opts.Encoding == nil {
+		var names []string
+		for _, f := range zr.File {
+			if _, ok := unicodePath(f); !ok && f.Flags&zipFlagUTF8 == 0 {
+				names = append(names, f.Name)
+			}
+		}
+		opts.Encoding = detectEncoding(names)
+	}
+	return &zipExtractor{zr: zr, ra: ra, size: size, opts: opts}, nil
+}
+
+func openSevenZip(ra io.ReaderAt, size int64, opts ExtractorOptions) (Extractor, error) {
+	zr, err := sevenzip.NewReader(ra, size)
+	if err != nil {
+		return nil, fmt.Errorf("sevenzip: %w", err)
+	}
+	if opts.Encoding == nil {
+		names := make([]string, len(zr.File))
+		for i, f := range zr.File {
+			names[i] = f.Name
+		}
+		opts.Encoding = detectEncoding(names)
+	}
+	return &sevenZipExtractor{zr: zr, opts: opts}, nil
+}
@@ -0,0 +1,2389 @@
+// Package gcsunzip extracts a zip or 7z archive on Google Cloud Storage and uploads its entries as objects.
+package gcsunzip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/api/googleapi"
+)
+
+const local = false
+
+const fileTimeoutAttempts = 3
+
+// Config configures Run. Zero values mean the defaults noted on the fields.
+type Config struct {
+	// Src is the gs:// URL of the archive.
+	Src string
+	// Dest is the gs:// URL of the destination prefix, which can contain {archive}, {date}, {entry} and {ext}.
+	Dest string
+	// Client is used for all GCS requests. A client is created with the retry options if nil,
+	// which also counts the transient errors in Stats.GCS.
+	Client *storage.Client
+
+	// Concurrency is the number of goroutines for uploading (default 24).
+	Concurrency int
+	// BufSize is the copy buffer size (default 512KiB).
+	BufSize uint64
+	// ChunkSize is the upload chunk size (default 16MiB).
+	ChunkSize uint64
+	// Layout is the naming of the objects: path (default) names them by the entry names, and cas by the SHA-256
+	// of the contents as <dest>/objects/ab/cdef... without compression, so identical files are stored once across archives,
+	// and writes <dest>/manifests/<archive>.jsonl of the entry names and their hashes.
+	Layout string
+	// Dedupe uploads only the first of the entries with the same CRC-32 and sizes, and copies its object
+	// to the others on the server side, which keep its Content-Encoding.
+	Dedupe bool
+	// SplitSize uploads the files larger than it as the objects <name>.part-0000, <name>.part-0001, ... of SplitSize bytes
+	// without compression, and then <name>.parts.json listing them, if positive.
+	SplitSize uint64
+	// GCInterval runs the garbage collector every GCInterval uploads if positive.
+	GCInterval int
+	// DiskLimit is the limit of the temporary files on the disk (default 50GiB).
+	// Run fails with ErrNoSpace if it cannot hold the largest entry, or Concurrency entries of the average size.
+	DiskLimit uint64
+	// TmpDir is the directory of the work directory (default os.TempDir).
+	TmpDir string
+
+	// GzipExt is the file extensions to compress before uploading.
+	GzipExt []string
+	// GzipTypes is the sniffed MIME types to compress before uploading (e.g. text/*, application/json).
+	GzipTypes []string
+	// GzipMinSize is the minimum size of files to compress.
+	GzipMinSize uint64
+	// Compress is the Content-Encoding of files selected by GzipExt and GzipTypes: gzip (default), zstd or br.
+	Compress string
+	// PrecompressedEncoding uploads gzip or zstd files as is with their Content-Encoding instead of skipping the compression.
+	PrecompressedEncoding bool
+	// ZstdExt is the file extensions to compress with zstd before uploading.
+	ZstdExt []string
+
+	// WithMeta uploads metadata entries such as __MACOSX and .DS_Store.
+	WithMeta bool
+	// SkipTop strips the top-level directory named after the archive if "true",
+	// or any single top-level directory if "auto" (default "false").
+	SkipTop string
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// Verify verifies uploaded objects against the archive after uploading.
+	Verify bool
+	// DryRun reads the archive with range requests and estimates the cost of the uploads in Stats.Cost
+	// without extracting it.
+	DryRun bool
+	// IfExists is the behavior when a destination object exists: skip, overwrite (default) or fail.
+	IfExists string
+	// Sync uploads only the entries which are new or changed from the destination objects, which implies IfExists skip.
+	Sync bool
+	// SyncBy is how Sync compares an entry with its object: checksum (default) compares the size and CRC32C
+	// after extracting the entry, mtime skips extracting the entry if the object is not older than it,
+	// and crc32 skips extracting the entry if the object was uploaded from an entry of the same CRC-32 and size.
+	SyncBy string
+	// DeleteExtraneous deletes the objects under the destination prefix which are not in the archive
+	// after all uploads succeed. It requires Sync.
+	DeleteExtraneous bool
+	// IfGenerationMatch uploads only if the destination object has this generation, where 0 means it must not exist.
+	// A non-zero generation is of a single object, so it requires an archive of a single file.
+	IfGenerationMatch *int64
+	// SuccessMarker is the name of the object written under the destination after all uploads succeed.
+	SuccessMarker string
+	// PreserveArchive is the gs:// URL of an object, or a prefix ending with a slash, to copy the source archive to
+	// on the server side after all uploads succeed and before SuccessMarker. Only the shard 0 copies it.
+	PreserveArchive string
+	// Manifest is a local file or gs:// object to write a JSON Lines manifest of uploaded objects.
+	Manifest string
+	// BQManifest is a BigQuery table ([project.]dataset.table) to stream a row per uploaded object into.
+	BQManifest string
+	// ContinueOnError continues with the remaining entries when an entry fails.
+	ContinueOnError bool
+	// ErrorReport is a local file or gs:// object to write a JSON Lines report of failed entries.
+	ErrorReport string
+
+	// RetryMaxAttempts is the maximum number of attempts for GCS requests (default unlimited).
+	RetryMaxAttempts int
+	// RetryInitialBackoff and RetryMaxBackoff are the backoff of GCS retries (default 1s and 30s).
+	RetryInitialBackoff time.Duration
+	RetryMaxBackoff     time.Duration
+	// RetryTimeout is the deadline of each GCS request with its retries: an upload chunk (default 32s), a read
+	// of the archive, a metadata request or a copy (default no deadline).
+	RetryTimeout time.Duration
+	// FileTimeout is the timeout for uploading each file, retried up to 3 times on expiry (default no timeout).
+	FileTimeout time.Duration
+
+	// MaxTotalSize is the maximum total uncompressed size of the archive (default unlimited).
+	MaxTotalSize uint64
+	// MaxRatio is the maximum compression ratio of an entry (default unlimited).
+	MaxRatio float64
+	// OnBomb is the behavior for entries exceeding MaxRatio or their declared size: abort (default) or skip.
+	OnBomb string
+	// MaxFiles is the maximum number of entries in the archive (default unlimited).
+	MaxFiles int
+
+	// Duplicates is the policy for entries with the same name: last-wins (default), first-wins, suffix or fail.
+	Duplicates string
+	// Sanitize is the policy for characters which are invalid in object names: none (default), replace or strip.
+	Sanitize string
+	// Normalize is the unicode normalization form of object names: nfc, nfd or none (default).
+	Normalize string
+	// Symlinks is the policy for symlink entries: skip (default), materialize or metadata.
+	Symlinks string
+	// KeepEmptyDirs uploads a zero-byte "dir/" object for each empty directory entry.
+	KeepEmptyDirs bool
+	// StrictNames fails if an entry name is neither valid UTF-8 nor decodable with the fallback encoding.
+	StrictNames bool
+	// UnsafePaths is the policy for absolute, drive-letter or parent-relative entry names: reject or rebase (default).
+	UnsafePaths string
+	// Encoding is the fallback encoding of entry names which are not valid UTF-8 (default shiftjis).
+	Encoding string
+	// Undecodable is the policy for names which cannot be decoded: hex, replace (default) or skip.
+	Undecodable string
+
+	// Include and Exclude are globs of entry names to extract and to skip after the includes.
+	Include []string
+	Exclude []string
+	// Only extracts only the entries matching these globs in addition to Include, e.g. a file named exactly,
+	// reading the archive with range requests instead of downloading it. Run fails if no entry matches.
+	Only []string
+	// IncludeRe and ExcludeRe are the regexp versions of Include and Exclude.
+	IncludeRe []*regexp.Regexp
+	ExcludeRe []*regexp.Regexp
+	// MinSize and MaxSize skip entries smaller or larger than them. MaxSize 0 means unlimited.
+	MinSize uint64
+	MaxSize uint64
+	// StripComponents drops the first N path components of entry names.
+	StripComponents int
+	// Flatten uploads files directly under the archive root by their base names.
+	Flatten bool
+	// FlattenCollisions is the policy for files with the same base name in Flatten: fail (default), suffix or hash.
+	FlattenCollisions string
+	// Renames rewrite entry names in order.
+	Renames []RenameRule
+	// NoArchivePrefix uploads entries directly under the destination prefix instead of <prefix>/<archive>/.
+	NoArchivePrefix bool
+	// TransformCmd is a shell command run on each file matching TransformInclude (all files if empty) before
+	// uploading, whose stdout replaces the file. {src} is replaced by the path of the file, which is piped to
+	// stdin otherwise, and the entry name is passed as GCS_UNZIP_ENTRY.
+	TransformCmd     string
+	TransformInclude []string
+	// Filter decides whether each extracted file is uploaded, skipped or failed before TransformCmd if not nil.
+	// It is called sequentially in the order of entries.
+	Filter func(context.Context, FilterEntry) (FilterDecision, error)
+
+	// ContentTypes maps file extensions to Content-Type.
+	ContentTypes map[string]string
+	// CacheControl is the Cache-Control of uploaded objects, overridden per extension by CacheControlExt.
+	CacheControl    string
+	CacheControlExt map[string]string
+	// ContentDisposition is the Content-Disposition of uploaded objects, where {name} is the base name.
+	// It is overridden per extension by ContentDispositionExt.
+	ContentDisposition    string
+	ContentDispositionExt map[string]string
+	// ContentLanguage is the Content-Language of uploaded objects, overridden per extension by ContentLanguageExt.
+	ContentLanguage    string
+	ContentLanguageExt map[string]string
+	// Metadata is the custom metadata of uploaded objects.
+	Metadata map[string]string
+	// AttrsRules set object attributes for entries matching their globs.
+	AttrsRules []AttrsRule
+	// StorageClass is the storage class of uploaded objects: STANDARD, NEARLINE, COLDLINE or ARCHIVE (default the bucket's).
+	StorageClass string
+	// KMSKey is the Cloud KMS key to encrypt uploaded objects.
+	KMSKey string
+	// EncryptionKey is the AES-256 customer-supplied key to read the source and write uploaded objects.
+	EncryptionKey []byte
+	// CustomTime is the CustomTime of uploaded objects: now, source-mtime (of the archive) or an RFC 3339 time.
+	CustomTime string
+
+	// Progress receives progress as JSON Lines every ProgressInterval (default 5s) if not nil.
+	Progress io.Writer
+	// ProgressFunc is called with progress every ProgressInterval if not nil, and once more at the end.
+	ProgressFunc     func(ProgressRecord)
+	ProgressInterval time.Duration
+	// HeartbeatInterval logs a line of the progress and the rate at info level at this interval if > 0,
+	// so that stalled jobs can be detected by logs.
+	HeartbeatInterval time.Duration
+	// Checkpoint is a local file or gs:// object to record uploaded entries for resuming.
+	Checkpoint string
+	// CheckpointInterval is the interval of saving the checkpoint, which is saved only at the end if 0.
+	CheckpointInterval time.Duration
+
+	// ShardIndex and ShardCount extract only the ShardIndex-th of ShardCount partitions of the entries if ShardCount > 1,
+	// reading the archive with range requests instead of downloading it. SuccessMarker, Manifest, ErrorReport and
+	// Checkpoint are suffixed with the index, e.g. _SUCCESS.3 and manifest.3.jsonl.
+	ShardIndex int
+	ShardCount int
+	// Shard extracts a shard planned by Plan if not nil, which overrides Src, Dest, ShardIndex and ShardCount.
+	Shard *ShardSpec
+
+	// RunReport is a local file or gs:// object to write the Summary of the job as JSON when it finishes.
+	// It can contain {archive}, {date} and {ts} (the job start time in UTC, e.g. 20060102T150405Z),
+	// and it is suffixed with the shard index if sharded.
+	RunReport string
+
+	// NotifyTopic is a Pub/Sub topic (projects/P/topics/T) to publish the Summary of the job as JSON when it finishes.
+	NotifyTopic string
+	// NotifyURL is a URL to POST the Summary of the job as JSON when it finishes, with retries.
+	// The request is signed by NotifySecret in SignatureHeader if not empty.
+	NotifyURL    string
+	NotifySecret string
+
+	// Budget is shared by concurrent jobs to limit their total disk, memory and uploads if not nil.
+	Budget *Budget
+
+	// Stop stops extracting new entries when it is closed, and Run returns ErrInterrupted
+	// after in-flight uploads finish. Cancel ctx to abort them.
+	Stop <-chan struct{}
+}
+
+// Report summarizes a job.
+type Report struct {
+	// Files and Bytes are the number and the total size of uploaded files.
+	Files int64
+	Bytes int64
+	// Failed is the number of entries which failed with Config.ContinueOnError.
+	Failed int
+	// Skipped is the number of entries which were skipped by Config.OnBomb skip, which are not failures.
+	Skipped int
+	// Renamed are the entries whose names were changed by Config.Sanitize.
+	Renamed []Rename
+	// Deleted is the number of extraneous objects deleted with Config.DeleteExtraneous.
+	Deleted int64
+	// Duration is the wall time of Run.
+	Duration time.Duration
+	// Stats are the statistics of the uploads.
+	Stats Stats
+}
+
+// Rename is an entry renamed by Config.Sanitize. Path is the sanitized name of the entry, and Object is
+// the gs:// URL of its object, which is empty with Config.Layout cas.
+type Rename struct {
+	Entry  string `json:"entry"`
+	Path   string `json:"path"`
+	Object string `json:"object,omitempty"`
+}
+
+func (c *Config) setDefaults() {
+	setDefault(&c.Concurrency, 24)
+	setDefault(&c.BufSize, 512*1024)
+	setDefault(&c.ChunkSize, 16*1024*1024)
+	setDefault(&c.DiskLimit, 50*1024*1024*1024)
+	setDefault(&c.Compress, "gzip")
+	setDefault(&c.SkipTop, "false")
+	setDefault(&c.IfExists, "overwrite")
+	setDefault(&c.SyncBy, "checksum")
+	setDefault(&c.Layout, "path")
+	setDefault(&c.OnBomb, "abort")
+	setDefault(&c.Duplicates, "last-wins")
+	setDefault(&c.Sanitize, "none")
+	setDefault(&c.Normalize, "none")
+	setDefault(&c.Symlinks, "skip")
+	setDefault(&c.UnsafePaths, "rebase")
+	setDefault(&c.Encoding, "shiftjis")
+	setDefault(&c.Undecodable, "replace")
+	setDefault(&c.FlattenCollisions, "fail")
+	setDefault(&c.ProgressInterval, 5*time.Second)
+	c.StorageClass = strings.ToUpper(c.StorageClass)
+}
+
+func setDefault[T comparable](p *T, v T) {
+	var zero T
+	if *p == zero {
+		*p = v
+	}
+}
+
+// Run extracts the archive of cfg.Src and uploads the entries under cfg.Dest.
+// The returned Report is filled as far as the job went even if it fails.
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	start := time.Now()
+	if s := cfg.Shard; s != nil {
+		cfg.Src, cfg.Dest = s.Src, s.Dest
+		cfg.ShardIndex, cfg.ShardCount = s.ShardIndex, s.ShardCount
+	}
+	ctx, span := tracer.Start(ctx, "gcsunzip.Run", trace.WithAttributes(
+		attribute.String("src", cfg.Src),
+		attribute.String("dest", cfg.Dest),
+		attribute.Int("shard_index", cfg.ShardIndex),
+		attribute.Int("shard_count", cfg.ShardCount),
+	))
+	var report Report
+	err := run(ctx, cfg, &report)
+	report.Duration = time.Since(start)
+	if !cfg.DryRun && (err == nil || report.Files > 0 || report.Failed > 0) {
+		logStats(report)
+	}
+	span.SetAttributes(attribute.Int64("files", report.Files), attribute.Int64("bytes", report.Bytes), attribute.Int("failed", report.Failed))
+	endSpan(span, err)
+	notify(ctx, cfg, start, report, err)
+	return report, err
+}
+
+func run(ctx context.Context, cfg Config, report *Report) error {
+	cfg.setDefaults()
+
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	dest, err := parseGSURL(cfg.Dest)
+	if err != nil {
+		return fmt.Errorf("%w: parse dest: %w", ErrUsage, err)
+	}
+	archiveName := trimExt(path.Base(src.Path))
+	dt, err := newDestTemplate(strings.TrimPrefix(dest.Path, "/"), archiveName, time.Now(), !cfg.NoArchivePrefix)
+	if err != nil {
+		return fmt.Errorf("%w: parse dest: %w", ErrUsage, err)
+	}
+
+	switch cfg.IfExists {
+	case "skip", "overwrite", "fail":
+	default:
+		return fmt.Errorf("%w: invalid IfExists: %s", ErrUsage, cfg.IfExists)
+	}
+	switch cfg.SyncBy {
+	case "checksum", "mtime", "crc32":
+	default:
+		return fmt.Errorf("%w: invalid SyncBy: %s", ErrUsage, cfg.SyncBy)
+	}
+	if cfg.Sync {
+		if cfg.IfExists == "fail" {
+			return fmt.Errorf("%w: Sync cannot be used with IfExists fail", ErrUsage)
+		}
+		cfg.IfExists = "skip"
+	}
+	if cfg.DeleteExtraneous {
+		switch {
+		case !cfg.Sync:
+			return fmt.Errorf("%w: DeleteExtraneous requires Sync", ErrUsage)
+		case cfg.ShardCount > 1 || cfg.Shard != nil:
+			return fmt.Errorf("%w: DeleteExtraneous cannot be used with shards", ErrUsage)
+		case dt.Prefix() == "" || dt.Object("a") != dt.Prefix()+"a":
+			// the prefix would contain the objects of the other archives, or the whole bucket.
+			return fmt.Errorf("%w: DeleteExtraneous needs a destination prefix which only contains the entries: %s", ErrUsage, cfg.Dest)
+		}
+	}
+	switch cfg.Layout {
+	case "path":
+	case "cas":
+		switch {
+		case strings.ContainsAny(dest.Path, "{}"):
+			return fmt.Errorf("%w: Layout cas needs a destination without variables: %s", ErrUsage, cfg.Dest)
+		case cfg.SplitSize > 0:
+			return fmt.Errorf("%w: Layout cas cannot be used with SplitSize", ErrUsage)
+		case cfg.DeleteExtraneous:
+			// the objects are shared with the other archives.
+			return fmt.Errorf("%w: Layout cas cannot be used with DeleteExtraneous", ErrUsage)
+		case cfg.Dedupe:
+			return fmt.Errorf("%w: Layout cas cannot be used with Dedupe, which it does by the hashes", ErrUsage)
+		case cfg.IfGenerationMatch != nil && *cfg.IfGenerationMatch != 0:
+			// the objects are written only if they do not exist.
+			return fmt.Errorf("%w: Layout cas cannot be used with a non-zero IfGenerationMatch", ErrUsage)
+		}
+	default:
+		return fmt.Errorf("%w: invalid Layout: %s", ErrUsage, cfg.Layout)
+	}
+	var preserved *url.URL
+	if cfg.PreserveArchive != "" {
+		preserved, err = preserveTarget(cfg.PreserveArchive, src)
+		if err != nil {
+			return fmt.Errorf("%w: invalid PreserveArchive: %w", ErrUsage, err)
+		}
+	}
+
+	nameEncoding, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return fmt.Errorf("%w: invalid Encoding: %w", ErrUsage, err)
+	}
+
+	switch cfg.Undecodable {
+	case "hex", "replace", "skip":
+	default:
+		return fmt.Errorf("%w: invalid Undecodable: %s", ErrUsage, cfg.Undecodable)
+	}
+
+	var normForm func(string) string
+	switch cfg.Normalize {
+	case "none":
+	case "nfc":
+		normForm = norm.NFC.String
+	case "nfd":
+		normForm = norm.NFD.String
+	default:
+		return fmt.Errorf("%w: invalid Normalize: %s", ErrUsage, cfg.Normalize)
+	}
+
+	switch cfg.Sanitize {
+	case "none", "replace", "strip":
+	default:
+		return fmt.Errorf("%w: invalid Sanitize: %s", ErrUsage, cfg.Sanitize)
+	}
+
+	switch cfg.UnsafePaths {
+	case "reject", "rebase":
+	default:
+		return fmt.Errorf("%w: invalid UnsafePaths: %s", ErrUsage, cfg.UnsafePaths)
+	}
+
+	switch cfg.Symlinks {
+	case "skip", "materialize", "metadata":
+	default:
+		return fmt.Errorf("%w: invalid Symlinks: %s", ErrUsage, cfg.Symlinks)
+	}
+
+	switch cfg.Duplicates {
+	case "last-wins", "first-wins", "suffix", "fail":
+	default:
+		return fmt.Errorf("%w: invalid Duplicates: %s", ErrUsage, cfg.Duplicates)
+	}
+
+	switch cfg.FlattenCollisions {
+	case "fail", "suffix", "hash":
+	default:
+		return fmt.Errorf("%w: invalid FlattenCollisions: %s", ErrUsage, cfg.FlattenCollisions)
+	}
+
+	switch cfg.SkipTop {
+	case "false", "true", "auto":
+	default:
+		return fmt.Errorf("%w: invalid SkipTop: %s", ErrUsage, cfg.SkipTop)
+	}
+
+	switch cfg.StorageClass {
+	case "", "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE":
+	default:
+		return fmt.Errorf("%w: invalid StorageClass: %s", ErrUsage, cfg.StorageClass)
+	}
+
+	if cfg.KMSKey != "" && (!strings.HasPrefix(cfg.KMSKey, "projects/") || !strings.Contains(cfg.KMSKey, "/cryptoKeys/")) {
+		return fmt.Errorf("%w: invalid KMSKey: %s", ErrUsage, cfg.KMSKey)
+	}
+
+	csek := cfg.EncryptionKey
+	if csek != nil {
+		if len(csek) != 32 {
+			return fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+		}
+		if cfg.KMSKey != "" {
+			return fmt.Errorf("%w: EncryptionKey and KMSKey are exclusive", ErrUsage)
+		}
+	}
+
+	var customTime time.Time
+	switch cfg.CustomTime {
+	case "", "source-mtime":
+	case "now":
+		customTime = time.Now()
+	default:
+		customTime, err = time.Parse(time.RFC3339, cfg.CustomTime)
+		if err != nil {
+			return fmt.Errorf("%w: parse CustomTime: %w", ErrUsage, err)
+		}
+	}
+
+	if _, ok := encoderPools[cfg.Compress]; !ok {
+		return fmt.Errorf("%w: invalid Compress: %s", ErrUsage, cfg.Compress)
+	}
+	contentTypes := normalizeExts(cfg.ContentTypes)
+	cacheControlExt := normalizeExts(cfg.CacheControlExt)
+	contentDispositionExt := normalizeExts(cfg.ContentDispositionExt)
+	contentLanguageExt := normalizeExts(cfg.ContentLanguageExt)
+	metadata := cfg.Metadata
+
+	attrsRules := slices.Clone(cfg.AttrsRules)
+	if err := validateAttrsRules(attrsRules); err != nil {
+		return fmt.Errorf("%w: AttrsRules: %w", ErrUsage, err)
+	}
+
+	switch cfg.OnBomb {
+	case "abort", "skip":
+	default:
+		return fmt.Errorf("%w: invalid OnBomb: %s", ErrUsage, cfg.OnBomb)
+	}
+
+	for _, p := range cfg.Include {
+		if !validGlob(p) {
+			return fmt.Errorf("%w: invalid Include: %s", ErrUsage, p)
+		}
+	}
+	for _, p := range cfg.Exclude {
+		if !validGlob(p) {
+			return fmt.Errorf("%w: invalid Exclude: %s", ErrUsage, p)
+		}
+	}
+	for _, p := range cfg.Only {
+		if !validGlob(p) {
+			return fmt.Errorf("%w: invalid Only: %s", ErrUsage, p)
+		}
+	}
+	for _, p := range cfg.TransformInclude {
+		if !validGlob(p) {
+			return fmt.Errorf("%w: invalid TransformInclude: %s", ErrUsage, p)
+		}
+	}
+
+	if _, err := reportPath(cfg.RunReport, cfg.Src, time.Now()); err != nil {
+		return fmt.Errorf("%w: invalid RunReport: %w", ErrUsage, err)
+	}
+	if cfg.NotifyTopic != "" && !topicPattern.MatchString(cfg.NotifyTopic) {
+		return fmt.Errorf("%w: invalid NotifyTopic: %s", ErrUsage, cfg.NotifyTopic)
+	}
+	if _, _, _, ok := parseTable(cfg.BQManifest); cfg.BQManifest != "" && !ok {
+		return fmt.Errorf("%w: invalid BQManifest: %s", ErrUsage, cfg.BQManifest)
+	}
+	if cfg.NotifyURL != "" {
+		if u, err := url.Parse(cfg.NotifyURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("%w: invalid NotifyURL: %s", ErrUsage, cfg.NotifyURL)
+		}
+	}
+
+	if cfg.StripComponents < 0 {
+		return fmt.Errorf("%w: invalid StripComponents: %d", ErrUsage, cfg.StripComponents)
+	}
+
+	var conds *storage.Conditions
+	switch g := cfg.IfGenerationMatch; {
+	case g == nil:
+	case *g == 0:
+		conds = &storage.Conditions{DoesNotExist: true}
+	default:
+		conds = &storage.Conditions{GenerationMatch: *g}
+	}
+
+	sharded := cfg.ShardCount > 1 || cfg.Shard != nil
+	if cfg.ShardCount < 0 || cfg.ShardIndex < 0 || cfg.ShardIndex >= max(cfg.ShardCount, 1) {
+		return fmt.Errorf("%w: invalid ShardIndex %d of ShardCount %d", ErrUsage, cfg.ShardIndex, cfg.ShardCount)
+	}
+	if cfg.DryRun {
+		// nothing is written by a dry run.
+		cfg.Checkpoint, cfg.BQManifest = "", ""
+	}
+	if sharded {
+		// each shard writes its own outputs, which would overwrite each other.
+		for _, p := range []*string{&cfg.SuccessMarker, &cfg.Manifest, &cfg.ErrorReport, &cfg.Checkpoint} {
+			if *p != "" {
+				*p = shardPath(*p, cfg.ShardIndex)
+			}
+		}
+	}
+
+	if !SupportedFormat(src.Path) {
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, path.Ext(src.Path))
+	}
+
+	// interruptCtx is canceled when cfg.Stop is closed. It stops extracting new entries,
+	// and in-flight uploads continue until ctx is canceled.
+	interruptCtx, interrupt := context.WithCancelCause(ctx)
+	defer interrupt(nil)
+	if cfg.Stop != nil {
+		go func() {
+			select {
+			case <-interruptCtx.Done():
+			case <-cfg.Stop:
+				interrupt(ErrInterrupted)
+			}
+		}()
+	}
+	interrupted := func() bool {
+		return errors.Is(context.Cause(interruptCtx), ErrInterrupted)
+	}
+
+	st := newStatsCollector()
+	defer func() {
+		report.Stats = st.result()
+	}()
+
+	diskLimit := cfg.DiskLimit
+	gcs := cfg.Client
+	if gcs == nil {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+		retryOpts := []storage.RetryOption{storage.WithErrorFunc(st.shouldRetry)}
+		if cfg.RetryInitialBackoff > 0 || cfg.RetryMaxBackoff > 0 {
+			retryOpts = append(retryOpts, storage.WithBackoff(gax.Backoff{
+				Initial:    cfg.RetryInitialBackoff,
+				Max:        cfg.RetryMaxBackoff,
+				Multiplier: 2,
+			}))
+		}
+		if cfg.RetryMaxAttempts > 0 {
+			retryOpts = append(retryOpts, storage.WithMaxAttempts(cfg.RetryMaxAttempts))
+		}
+		gcs.SetRetry(retryOpts...)
+	}
+
+	var cp *checkpoint
+	if cfg.Checkpoint != "" {
+		cp, err = openCheckpoint(ctx, gcs, cfg.Checkpoint)
+		if err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+		debugf("checkpoint: %d entries already uploaded", cp.Len())
+		defer func() {
+			if err := cp.Flush(context.WithoutCancel(ctx)); err != nil {
+				warnf("failed to flush checkpoint: %v", err)
+			}
+		}()
+	}
+
+	workDir, err := os.MkdirTemp(cfg.TmpDir, "")
+	if err != nil {
+		return fmt.Errorf("make work dir: %w", err)
+	}
+	defer func() {
+		err := os.RemoveAll(workDir)
+		if err != nil {
+			warnf("failed to remove work dir: %v", err)
+		}
+	}()
+
+	prog := &progress{}
+	if cfg.HeartbeatInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go prog.heartbeat(cfg.HeartbeatInterval, stop)
+	}
+
+	// archive is the downloaded archive, or the remote one read with range requests by a shard or for Only.
+	var archive io.ReaderAt
+	var archiveSize int64
+	var archiveMtime time.Time
+	var archiveGeneration int64
+	if sharded || cfg.DryRun || len(cfg.Only) > 0 {
+		var generation int64
+		if cfg.Shard != nil {
+			generation = cfg.Shard.Generation
+		}
+		prog.setStage("open")
+		ra, size, err := openSource(ctx, gcs, src, csek, generation, cfg.RetryTimeout)
+		if err != nil {
+			return err
+		}
+		archive, archiveSize = ra, size
+		if r, ok := ra.(*remoteReaderAt); ok {
+			archiveMtime, archiveGeneration = r.ModTime(), r.Generation()
+			st.gcsOp("metadata", 1)
+			defer func() {
+				st.gcsOp("read", r.Requests())
+			}()
+		}
+		debugf("read %s with range requests", src.String())
+	} else {
+		prog.setStage("download")
+		downloadStart := time.Now()
+		logEvent(slog.LevelDebug, []slog.Attr{slog.String("event", "download_start"), slog.String("src", src.String())}, "download %s", src.String())
+		dctx, span := tracer.Start(ctx, "download", trace.WithAttributes(attribute.String("src", src.String())))
+		zipPath, generation, err := download(dctx, gcs, workDir, src, csek, cfg.RetryTimeout)
+		endSpan(span, err)
+		if !local {
+			st.gcsOp("read", 1)
+		}
+		st.stage("download", downloadStart)
+		if err != nil {
+			return fmt.Errorf("download zip: %w", err)
+		}
+		zf, err := os.Open(zipPath)
+		if err != nil {
+			return fmt.Errorf("open zip file: %w", err)
+		}
+		defer zf.Close()
+		fi, err := zf.Stat()
+		if err != nil {
+			return fmt.Errorf("stat zip file: %w", err)
+		}
+		logEvent(slog.LevelDebug, []slog.Attr{
+			slog.String("event", "download_finish"),
+			slog.String("src", src.String()),
+			slog.Int64("bytes", fi.Size()),
+			slog.Duration("duration", time.Since(downloadStart)),
+		}, "download finished: -> %s", zipPath)
+		archive, archiveSize, archiveMtime, archiveGeneration = zf, fi.Size(), fi.ModTime(), generation
+		st.work("download", fi.Size(), time.Since(downloadStart))
+	}
+	if cp != nil {
+		if err := cp.Bind(src.String(), archiveGeneration); err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+
+	bucket := gcs.Bucket(dest.Hostname())
+	object := func(name string) *storage.ObjectHandle {
+		o := bucket.Object(name)
+		if csek != nil {
+			o = o.Key(csek)
+		}
+		return o
+	}
+	// attrsOf gets the attributes of the object, bounding the request with its retries by RetryTimeout.
+	attrsOf := func(ctx context.Context, o *storage.ObjectHandle) (*storage.ObjectAttrs, error) {
+		ctx, cancel := opContext(ctx, cfg.RetryTimeout)
+		defer cancel()
+		return o.Attrs(ctx)
+	}
+
+	uploadBufPool := sync.Pool{
+		New: func() any {
+			return make([]byte, cfg.BufSize)
+		},
+	}
+	useGzip := map[string]bool{}
+	for _, ext := range cfg.GzipExt {
+		useGzip["."+strings.ToLower(ext)] = true
+	}
+	useZstd := map[string]bool{}
+	for _, ext := range cfg.ZstdExt {
+		useZstd["."+strings.ToLower(ext)] = true
+	}
+	// entryName returns the slash-separated name under the archive root of the path relative to the work dir.
+	entryName := func(f string) string {
+		return strings.TrimPrefix(filepath.ToSlash(f), archiveName+"/")
+	}
+	objectName := func(f string) string {
+		return dt.Object(entryName(f))
+	}
+	// extEncoding returns the Content-Encoding of the file by its extension.
+	extEncoding := func(f string) string {
+		switch ext := strings.ToLower(filepath.Ext(f)); {
+		case useZstd[ext]:
+			return "zstd"
+		case useGzip[ext]:
+			return cfg.Compress
+		}
+		return ""
+	}
+	// contentEncoding decides the Content-Encoding of the extracted file by its extension, size and sniffed content type.
+	// raw is the Content-Encoding of the file if it is already compressed and uploaded as is.
+	contentEncoding := func(f string, size int64) (enc, raw string) {
+		if size < int64(cfg.GzipMinSize) {
+			return "", ""
+		}
+		enc = extEncoding(f)
+		if enc == "" && len(cfg.GzipTypes) > 0 {
+			if typ, err := sniffContentType(filepath.Join(workDir, f)); err == nil && matchMIMEType(cfg.GzipTypes, typ) {
+				enc = cfg.Compress
+			}
+		}
+		if gz := applyAttrsRules(attrsRules, entryName(f)).Gzip; gz != nil {
+			enc = ""
+			if *gz {
+				enc = cfg.Compress
+			}
+		}
+		if enc == "" {
+			return "", ""
+		}
+		if pre, ok := precompressed(filepath.Join(workDir, f)); ok {
+			debugf("skip compression of already compressed file: %s", f)
+			if cfg.PrecompressedEncoding {
+				return "", pre
+			}
+			return "", ""
+		}
+		return enc, ""
+	}
+	// existing are the objects under the destination prefix, which are listed once after scanning the archive
+	// instead of a request per entry for SyncBy mtime and crc32, and DeleteExtraneous.
+	var existing map[string]*storage.ObjectAttrs
+	var mf *manifest
+	if cfg.Manifest != "" {
+		mf = &manifest{}
+	}
+	// casRoot is the prefix of the objects and the manifests by Layout cas.
+	casRoot := strings.Trim(dest.Path, "/")
+	var cas *casManifest
+	var casDeduped atomic.Int64
+	if cfg.Layout == "cas" {
+		cas = &casManifest{}
+	}
+	var bq *bqManifest
+	if cfg.BQManifest != "" {
+		var err error
+		bq, err = newBQManifest(ctx, cfg.BQManifest, cfg.Src)
+		if err != nil {
+			return fmt.Errorf("bq manifest: %w", err)
+		}
+		defer bq.Close()
+	}
+	var fl failures
+	var count atomic.Int64
+	// skipped is the number of entries skipped by OnBomb skip.
+	var skipped int
+	defer func() {
+		report.Files = count.Load()
+		report.Bytes = prog.bytes.Load()
+		report.Failed = fl.Len()
+		report.Skipped = skipped
+	}()
+	uploadsStart := time.Now()
+
+	// setObjectAttrs sets the attributes of the object of the file by the flags and the rules.
+	setObjectAttrs := func(a *storage.ObjectAttrs, f string) {
+		a.ContentType = contentTypes[fileExt(f)]
+		a.CacheControl = extAttr(f, cfg.CacheControl, cacheControlExt)
+		a.ContentDisposition = expandDisposition(extAttr(f, cfg.ContentDisposition, contentDispositionExt), f)
+		a.ContentLanguage = extAttr(f, cfg.ContentLanguage, contentLanguageExt)
+		a.StorageClass = cfg.StorageClass
+		a.CustomTime = customTime
+		ar := applyAttrsRules(attrsRules, entryName(f))
+		if ar.ContentType != "" {
+			a.ContentType = ar.ContentType
+		}
+		if ar.CacheControl != "" {
+			a.CacheControl = ar.CacheControl
+		}
+		if ar.ContentDisposition != "" {
+			a.ContentDisposition = expandDisposition(ar.ContentDisposition, f)
+		}
+		if ar.ContentLanguage != "" {
+			a.ContentLanguage = ar.ContentLanguage
+		}
+		if ar.StorageClass != "" {
+			a.StorageClass = ar.StorageClass
+		}
+		if len(metadata)+len(ar.Metadata) > 0 {
+			a.Metadata = map[string]string{}
+			maps.Copy(a.Metadata, metadata)
+			maps.Copy(a.Metadata, ar.Metadata)
+		}
+	}
+	// newObjectWriter returns the writer of the object of the file with the attributes by the flags and the rules.
+	newObjectWriter := func(ctx context.Context, o *storage.ObjectHandle, f string) *storage.Writer {
+		ow := o.NewWriter(ctx)
+		ow.ChunkSize = int(cfg.ChunkSize)
+		ow.ChunkRetryDeadline = cfg.RetryTimeout
+		ow.KMSKeyName = cfg.KMSKey
+		setObjectAttrs(&ow.ObjectAttrs, f)
+		return ow
+	}
+	// split reports whether the file of size bytes is uploaded as parts.
+	split := func(size int64) bool {
+		return cfg.SplitSize > 0 && size > int64(cfg.SplitSize)
+	}
+	// uploadParts uploads the file larger than SplitSize as its parts and then the parts manifest,
+	// which is the object checked by IfExists and IfGenerationMatch.
+	uploadParts := func(ctx context.Context, job uploadJob, r *os.File) error {
+		name := objectName(job.name)
+		mo := object(name + partsManifestSuffix).Retryer(storage.WithPolicy(storage.RetryAlways))
+		sum := fmt.Sprintf("%08x", job.crc)
+		if _, listed := existing[mo.ObjectName()]; cfg.IfExists != "overwrite" && (existing == nil || listed) {
+			st.gcsOp("metadata", 1)
+			attrs, err := attrsOf(ctx, mo)
+			switch {
+			case errors.Is(err, storage.ErrObjectNotExist):
+			case err != nil:
+				return fmt.Errorf("stat object: %w", err)
+			case cfg.IfExists == "fail":
+				return fmt.Errorf("object already exists: %s", mo.ObjectName())
+			case attrs.Metadata["crc32c"] == sum && attrs.Metadata["size"] == strconv.FormatInt(job.size, 10):
+				debugf("skip existing: gs://%s", path.Join(mo.BucketName(), mo.ObjectName()))
+				if cp != nil {
+					cp.Add(job.name, job.crc, job.entryCRC)
+				}
+				return nil
+			}
+		}
+
+		buf := uploadBufPool.Get().([]byte)
+		defer uploadBufPool.Put(buf)
+		start := time.Now()
+		m := partsManifest{Entry: job.entry, Object: name, Size: job.size, CRC32C: sum, PartSize: int64(cfg.SplitSize)}
+		for i := 0; int64(i)*int64(cfg.SplitSize) < job.size; i++ {
+			off := int64(i) * int64(cfg.SplitSize)
+			n := min(int64(cfg.SplitSize), job.size-off)
+			po := object(partName(name, i)).Retryer(storage.WithPolicy(storage.RetryAlways))
+			st.gcsOp("write", 1)
+			ow := newObjectWriter(ctx, po, job.name)
+			h := crc32.New(crc32cTable)
+			if _, err := io.CopyBuffer(io.MultiWriter(ow, h), io.NewSectionReader(r, off, n), buf); err != nil {
+				ow.Close()
+				return fmt.Errorf("upload part %d: %w", i, err)
+			}
+			if err := ow.Close(); err != nil {
+				return fmt.Errorf("close writer of part %d: %w", i, err)
+			}
+			if got, want := ow.Attrs().CRC32C, h.Sum32(); got != want {
+				st.gcsOp("delete", 1)
+				if err := po.Delete(ctx); err != nil {
+					warnf("failed to delete corrupted object: %v", err)
+				}
+				return fmt.Errorf("crc32c mismatch of part %d: got %08x, want %08x", i, got, want)
+			}
+			st.charge(ow.StorageClass, 1, ow.Attrs().Size)
+			m.Parts = append(m.Parts, partObject{Name: po.ObjectName(), Size: n, CRC32C: fmt.Sprintf("%08x", h.Sum32())})
+			if mf != nil {
+				mf.Add(job.entry, ow.Attrs())
+			}
+			if bq != nil {
+				bq.Add(job.entry, ow.Attrs())
+			}
+			debugf("-> gs://%s(%s)", path.Join(po.BucketName(), po.ObjectName()), FormatBytes(uint64(n)))
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("marshal parts manifest: %w", err)
+		}
+		wo := mo
+		if conds != nil {
+			wo = mo.If(*conds)
+		}
+		st.gcsOp("write", 1)
+		mw := wo.NewWriter(ctx)
+		mw.ContentType = "application/json"
+		mw.KMSKeyName = cfg.KMSKey
+		mw.Metadata = map[string]string{"crc32c": sum, "size": strconv.FormatInt(job.size, 10)}
+		if _, err := mw.Write(b); err != nil {
+			mw.Close()
+			return fmt.Errorf("write parts manifest: %w", err)
+		}
+		if err := mw.Close(); err != nil {
+			var gerr *googleapi.Error
+			if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+				return fmt.Errorf("precondition failed(%s): %w", mo.ObjectName(), err)
+			}
+			return fmt.Errorf("close writer: %w", err)
+		}
+		st.upload(job.name, job.entry, job.size, job.size, false, time.Since(start))
+		st.charge("", 1, int64(len(b)))
+		c := count.Add(1)
+		prog.files.Add(1)
+		prog.bytes.Add(job.size)
+		logEvent(slog.LevelDebug, []slog.Attr{
+			slog.String("event", "upload"),
+			slog.String("entry", job.entry),
+			slog.String("object", "gs://"+path.Join(mo.BucketName(), mo.ObjectName())),
+			slog.Int64("bytes", job.size),
+			slog.Int("parts", len(m.Parts)),
+			slog.Duration("duration", time.Since(start)),
+		}, "%7d: -> %s(%s, %d parts): %s", c, "gs://"+path.Join(mo.BucketName(), mo.ObjectName()), FormatBytes(uint64(job.size)), len(m.Parts), time.Since(start))
+		if cp != nil {
+			cp.Add(job.name, job.crc, job.entryCRC)
+		}
+		return nil
+	}
+	// uploadCAS uploads the file as the object named by its SHA-256 unless the object already exists.
+	// The object is written only if it does not exist, so concurrent jobs writing the same content do not conflict.
+	uploadCAS := func(ctx context.Context, job uploadJob, r *os.File) error {
+		e := casEntry{Path: entryName(job.name), Size: job.size, LinkTarget: job.linkTarget}
+		if job.linkTarget != "" {
+			cas.Add(e)
+			return nil
+		}
+		buf := uploadBufPool.Get().([]byte)
+		defer uploadBufPool.Put(buf)
+		start := time.Now()
+		h := sha256.New()
+		if _, err := io.CopyBuffer(h, r, buf); err != nil {
+			return fmt.Errorf("hash: %w", err)
+		}
+		e.SHA256 = hex.EncodeToString(h.Sum(nil))
+		e.Object = casObjectName(casRoot, e.SHA256)
+		o := object(e.Object).Retryer(storage.WithPolicy(storage.RetryAlways))
+		st.gcsOp("metadata", 1)
+		attrs, err := attrsOf(ctx, o)
+		deduped := err == nil
+		switch {
+		case err == nil:
+		case errors.Is(err, storage.ErrObjectNotExist):
+			if _, err := r.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("seek: %w", err)
+			}
+			st.gcsOp("write", 1)
+			ow := newObjectWriter(ctx, o.If(storage.Conditions{DoesNotExist: true}), job.name)
+			if ow.Metadata == nil {
+				ow.Metadata = map[string]string{}
+			}
+			ow.Metadata["sha256"] = e.SHA256
+			ow.CRC32C = job.crc
+			ow.SendCRC32C = true
+			if _, err := io.CopyBuffer(ow, r, buf); err != nil {
+				ow.Close()
+				return fmt.Errorf("upload: %w", err)
+			}
+			err := ow.Close()
+			var gerr *googleapi.Error
+			switch {
+			case errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed:
+				// written by another job after Attrs.
+				deduped = true
+			case err != nil:
+				return fmt.Errorf("close writer: %w", err)
+			default:
+				attrs = ow.Attrs()
+				st.charge(ow.StorageClass, 1, attrs.Size)
+			}
+		default:
+			return fmt.Errorf("stat object: %w", err)
+		}
+		if deduped {
+			casDeduped.Add(1)
+			debugf("deduplicated: %s -> gs://%s", job.entry, path.Join(o.BucketName(), o.ObjectName()))
+		}
+		cas.Add(e)
+		st.upload(job.name, job.entry, job.size, job.size, false, time.Since(start))
+		c := count.Add(1)
+		prog.files.Add(1)
+		prog.bytes.Add(job.size)
+		logEvent(slog.LevelDebug, []slog.Attr{
+			slog.String("event", "upload"),
+			slog.String("entry", job.entry),
+			slog.String("object", "gs://"+path.Join(o.BucketName(), o.ObjectName())),
+			slog.Int64("bytes", job.size),
+			slog.Duration("duration", time.Since(start)),
+		}, "%7d: -> %s(%s): %s", c, "gs://"+path.Join(o.BucketName(), o.ObjectName()), FormatBytes(uint64(job.size)), time.Since(start))
+		if cp != nil {
+			cp.Add(job.name, job.crc, job.entryCRC)
+		}
+		if attrs != nil {
+			if mf != nil {
+				mf.Add(job.entry, attrs)
+			}
+			if bq != nil {
+				bq.Add(job.entry, attrs)
+			}
+		}
+		return nil
+	}
+	// copyEntry creates the object of an entry identical to another one by copying the object of the other
+	// on the server side after its upload, keeping its Content-Encoding.
+	copyEntry := func(ctx context.Context, job uploadJob) error {
+		src := job.copyOf.attrs
+		if src == nil {
+			return fmt.Errorf("copy: the upload of the identical entry %s failed", job.copyOf.entry)
+		}
+		name := objectName(job.name)
+		o := object(name).Retryer(storage.WithPolicy(storage.RetryAlways))
+		if _, listed := existing[name]; cfg.IfExists != "overwrite" && (existing == nil || listed) {
+			st.gcsOp("metadata", 1)
+			attrs, err := attrsOf(ctx, o)
+			switch {
+			case errors.Is(err, storage.ErrObjectNotExist):
+			case err != nil:
+				return fmt.Errorf("stat object: %w", err)
+			case cfg.IfExists == "fail":
+				return fmt.Errorf("object already exists: %s", name)
+			case attrs.Size == src.Size && attrs.CRC32C == src.CRC32C && attrs.ContentEncoding == src.ContentEncoding:
+				debugf("skip existing: gs://%s", path.Join(o.BucketName(), o.ObjectName()))
+				if cp != nil {
+					cp.Add(job.name, src.CRC32C, job.entryCRC)
+				}
+				if mf != nil {
+					mf.Add(job.entry, attrs)
+				}
+				if bq != nil {
+					bq.Add(job.entry, attrs)
+				}
+				return nil
+			}
+		}
+		wo := o
+		if conds != nil {
+			wo = o.If(*conds)
+		}
+		start := time.Now()
+		st.gcsOp("copy", 1)
+		c := wo.CopierFrom(object(src.Name).Generation(src.Generation))
+		setObjectAttrs(&c.ObjectAttrs, job.name)
+		if c.ContentType == "" {
+			c.ContentType = src.ContentType
+		}
+		c.ContentEncoding = src.ContentEncoding
+		c.DestinationKMSKeyName = cfg.KMSKey
+		setEntryChecksum(&c.ObjectAttrs, job.checksum, job.declared)
+		cctx, cancel := opContext(ctx, cfg.RetryTimeout)
+		attrs, err := c.Run(cctx)
+		cancel()
+		if err != nil {
+			var gerr *googleapi.Error
+			if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+				return fmt.Errorf("precondition failed(%s): %w", name, err)
+			}
+			return fmt.Errorf("copy: %w", err)
+		}
+		st.upload(job.name, job.entry, int64(job.declared), attrs.Size, attrs.ContentEncoding != "", time.Since(start))
+		st.charge(attrs.StorageClass, 1, attrs.Size)
+		n := count.Add(1)
+		prog.files.Add(1)
+		prog.bytes.Add(int64(job.declared))
+		logEvent(slog.LevelDebug, []slog.Attr{
+			slog.String("event", "copy"),
+			slog.String("entry", job.entry),
+			slog.String("object", "gs://"+path.Join(attrs.Bucket, attrs.Name)),
+			slog.String("source", "gs://"+path.Join(src.Bucket, src.Name)),
+			slog.Int64("bytes", int64(job.declared)),
+			slog.Duration("duration", time.Since(start)),
+		}, "%7d: -> gs://%s(copy of %s): %s", n, path.Join(attrs.Bucket, attrs.Name), job.copyOf.entry, time.Since(start))
+		if cp != nil {
+			cp.Add(job.name, attrs.CRC32C, job.entryCRC)
+		}
+		if mf != nil {
+			mf.Add(job.entry, attrs)
+		}
+		if bq != nil {
+			bq.Add(job.entry, attrs)
+		}
+		return nil
+	}
+	upload := func(ctx context.Context, job uploadJob) error {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		if job.copyOf != nil {
+			return copyEntry(ctx, job)
+		}
+		f, crc := job.name, job.crc
+		r, err := os.Open(filepath.Join(workDir, f))
+		if err != nil {
+			return fmt.Errorf("open upload file: %w", err)
+		}
+		defer r.Close()
+		if cas != nil {
+			return uploadCAS(ctx, job, r)
+		}
+		if split(job.size) {
+			return uploadParts(ctx, job, r)
+		}
+
+		name := objectName(f)
+		o := object(name).Retryer(storage.WithPolicy(storage.RetryAlways))
+		if _, listed := existing[name]; cfg.IfExists != "overwrite" && (existing == nil || listed) {
+			st.gcsOp("metadata", 1)
+			attrs, err := attrsOf(ctx, o)
+			switch {
+			case errors.Is(err, storage.ErrObjectNotExist):
+			case err != nil:
+				return fmt.Errorf("stat object: %w", err)
+			case cfg.IfExists == "fail":
+				return fmt.Errorf("object already exists: %s", name)
+			case sameObject(attrs, r, crc, job.encoding):
+				debugf("skip existing: gs://%s", path.Join(o.BucketName(), o.ObjectName()))
+				if cfg.SyncBy == "crc32" && job.encoding == "" && job.checksum != "" && !unchangedEntry(attrs, job.checksum, job.declared) {
+					// the object was uploaded without the checksum of its entry, which is recorded to skip it in later runs.
+					a := storage.ObjectAttrs{Metadata: maps.Clone(attrs.Metadata)}
+					setEntryChecksum(&a, job.checksum, job.declared)
+					st.gcsOp("metadata", 1)
+					uctx, cancel := opContext(ctx, cfg.RetryTimeout)
+					updated, err := o.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration}).Update(uctx, storage.ObjectAttrsToUpdate{Metadata: a.Metadata})
+					cancel()
+					if err != nil {
+						warnf("failed to record the entry checksum(%s): %v", name, err)
+					} else {
+						attrs = updated
+					}
+				}
+				if job.source != nil {
+					job.source.attrs = attrs
+				}
+				if cp != nil {
+					cp.Add(f, crc, job.entryCRC)
+				}
+				if mf != nil {
+					mf.Add(job.entry, attrs)
+				}
+				if bq != nil {
+					bq.Add(job.entry, attrs)
+				}
+				return nil
+			}
+		}
+		wo := o
+		if conds != nil {
+			wo = o.If(*conds)
+		}
+		st.gcsOp("write", 1)
+		ow := newObjectWriter(ctx, wo, f)
+		setEntryChecksum(&ow.ObjectAttrs, job.checksum, job.declared)
+		defer ow.Close()
+
+		var w io.Writer
+		var closeWriter func() error
+		if job.encoding != "" {
+			if ow.ContentType == "" {
+				if sniff, err := io.ReadAll(io.NewSectionReader(r, 0, 512)); err == nil {
+					ow.ContentType = http.DetectContentType(sniff)
+				}
+			}
+			ow.ContentEncoding = job.encoding
+			pool := encoderPools[job.encoding]
+			gw := pool.Get().(encoder)
+			defer pool.Put(gw)
+			// the checksum of the compressed stream is unknown until the end,
+			// so it is verified against the stored object after the upload.
+			h := crc32.New(crc32cTable)
+			gw.Reset(io.MultiWriter(ow, h))
+
+			closeWriter = func() error {
+				if err := gw.Close(); err != nil {
+					return err
+				}
+				if err := ow.Close(); err != nil {
+					return err
+				}
+				if got, want := ow.Attrs().CRC32C, h.Sum32(); got != want {
+					st.gcsOp("delete", 1)
+					if err := o.Delete(ctx); err != nil {
+						warnf("failed to delete corrupted object: %v", err)
+					}
+					return fmt.Errorf("crc32c mismatch: got %08x, want %08x", got, want)
+				}
+				return nil
+			}
+			w = gw
+		} else {
+			if job.linkTarget != "" {
+				if ow.Metadata == nil {
+					ow.Metadata = map[string]string{}
+				}
+				ow.Metadata["symlink-target"] = job.linkTarget
+			}
+			ow.ContentEncoding = job.rawEncoding
+			ow.CRC32C = crc
+			ow.SendCRC32C = true
+			closeWriter = ow.Close
+			w = ow
+		}
+
+		buf := uploadBufPool.Get().([]byte)
+		defer uploadBufPool.Put(buf)
+
+		start := time.Now()
+		uploaded, err := io.CopyBuffer(w, r, buf)
+		if err != nil {
+			return fmt.Errorf("upload: %w", err)
+		}
+		if err := closeWriter(); err != nil {
+			var gerr *googleapi.Error
+			if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+				return fmt.Errorf("precondition failed(%s): %w", name, err)
+			}
+			return fmt.Errorf("close writer: %w", err)
+		}
+		st.upload(f, job.entry, uploaded, ow.Attrs().Size, job.encoding != "", time.Since(start))
+		st.charge(ow.StorageClass, 1, ow.Attrs().Size)
+		c := count.Add(1)
+		prog.files.Add(1)
+		prog.bytes.Add(uploaded)
+		if cfg.GCInterval > 0 && int(c)%cfg.GCInterval == 0 {
+			runtime.GC()
+		}
+		logEvent(slog.LevelDebug, []slog.Attr{
+			slog.String("event", "upload"),
+			slog.String("entry", job.entry),
+			slog.String("object", "gs://"+path.Join(o.BucketName(), o.ObjectName())),
+			slog.Int64("bytes", uploaded),
+			slog.Duration("duration", time.Since(start)),
+		}, "%7d: -> %s(%s): %s", c, "gs://"+path.Join(o.BucketName(), o.ObjectName()), FormatBytes(uint64(uploaded)), time.Now().Sub(start))
+		if cp != nil {
+			cp.Add(f, crc, job.entryCRC)
+		}
+		if mf != nil {
+			mf.Add(job.entry, ow.Attrs())
+		}
+		if bq != nil {
+			bq.Add(job.entry, ow.Attrs())
+		}
+		if job.source != nil {
+			job.source.attrs = ow.Attrs()
+		}
+		return nil
+	}
+	if cfg.FileTimeout > 0 {
+		uploadOnce := upload
+		upload = func(ctx context.Context, job uploadJob) error {
+			for attempt := 1; ; attempt++ {
+				fctx, cancel := context.WithTimeout(ctx, cfg.FileTimeout)
+				err := uploadOnce(fctx, job)
+				expired := errors.Is(fctx.Err(), context.DeadlineExceeded)
+				cancel()
+				if err == nil || !expired || ctx.Err() != nil || attempt == fileTimeoutAttempts {
+					return err
+				}
+				warnf("upload timed out(%d/%d): %s", attempt, fileTimeoutAttempts, job.entry)
+				st.retry()
+			}
+		}
+	}
+	uploadUntraced := upload
+	upload = func(ctx context.Context, job uploadJob) error {
+		ctx, span := tracer.Start(ctx, "upload", trace.WithAttributes(
+			attribute.String("entry", job.entry),
+			attribute.Int64("size", job.size),
+			attribute.String("content_encoding", job.encoding),
+		))
+		err := uploadUntraced(ctx, job)
+		endSpan(span, err)
+		return err
+	}
+	if local {
+		upload = func(ctx context.Context, job uploadJob) error {
+			infof("-> %s", job.name)
+			return nil
+		}
+	}
+
+	if cfg.CustomTime == "source-mtime" {
+		customTime = archiveMtime
+	}
+
+	extractor, err := newExtractor(archive, archiveSize, src.Path, ExtractorOptions{
+		OldWindows:     cfg.OldWindows,
+		Encoding:       nameEncoding,
+		HexUndecodable: cfg.Undecodable == "hex",
+	})
+	if err != nil {
+		return fmt.Errorf("extractor: %w", err)
+	}
+	if cfg.MaxFiles > 0 && extractor.Files() > cfg.MaxFiles {
+		return fmt.Errorf("too many entries: %d > %d", extractor.Files(), cfg.MaxFiles)
+	}
+	if cfg.StrictNames {
+		var invalid []string
+		for i := 0; i < extractor.Files(); i++ {
+			if !extractor.ValidName(i) {
+				invalid = append(invalid, strconv.Quote(extractor.FileName(i)))
+			}
+		}
+		if len(invalid) > 0 {
+			if len(invalid) > 20 {
+				invalid = append(invalid[:20], fmt.Sprintf("and %d more", len(invalid)-20))
+			}
+			return fmt.Errorf("undecodable names: %s", strings.Join(invalid, ", "))
+		}
+	}
+
+	checkRatio := func(i int) error {
+		if cfg.MaxRatio <= 0 {
+			return nil
+		}
+		size, csize := extractor.FileSize(i), extractor.CompressedSize(i)
+		if csize == 0 {
+			return nil
+		}
+		if ratio := float64(size) / float64(csize); ratio > cfg.MaxRatio {
+			return fmt.Errorf("%w: compression ratio %.0f", errBomb, ratio)
+		}
+		return nil
+	}
+
+	// topDir is the top-level directory stripped by Config.SkipTop.
+	var topDir string
+	if cfg.SkipTop != "false" {
+		top, single := "", true
+		for i := 0; i < extractor.Files() && single; i++ {
+			if extractor.IsDir(i) {
+				continue
+			}
+			name := extractor.FileName(i)
+			if !cfg.WithMeta && isIgnoreMeta(name) {
+				continue
+			}
+			t, _, found := strings.Cut(name, string(os.PathSeparator))
+			single = found && (top == "" || t == top)
+			top = t
+		}
+		if single && (cfg.SkipTop == "auto" || top == archiveName) {
+			topDir = top
+		}
+	}
+
+	files := map[string]int{}
+	for i := 0; i < extractor.Files(); i++ {
+		if !extractor.IsDir(i) {
+			files[extractor.FileName(i)] = i
+		}
+	}
+	// linkSources maps materialized symlink entries to the entries holding their content.
+	linkSources := map[int]int{}
+	linkTargets := map[int]string{}
+	source := func(i int) int {
+		if j, ok := linkSources[i]; ok {
+			return j
+		}
+		return i
+	}
+
+	filter := &entryFilter{only: cfg.Only, include: cfg.Include, exclude: cfg.Exclude, includeRe: cfg.IncludeRe, excludeRe: cfg.ExcludeRe}
+
+	// names holds the path relative to the work dir for each entry, empty for skipped entries.
+	names := make([]string, extractor.Files())
+	// sanitized are the entries renamed by Sanitize, which are reported.
+	sanitized := make([]bool, len(names))
+	var unsafe []string
+	for i := range names {
+		name := extractor.FileName(i)
+		if !cfg.WithMeta && isIgnoreMeta(name) {
+			continue
+		}
+		if cfg.Undecodable == "skip" && !extractor.ValidName(i) {
+			warnf("skip undecodable name: %q", name)
+			continue
+		}
+		if isUnsafePath(name) {
+			if cfg.UnsafePaths == "reject" {
+				unsafe = append(unsafe, strconv.Quote(name))
+				continue
+			}
+			s := rebasePath(name)
+			warnf("unsafe path %q is rebased to %q", name, s)
+			if s == "" {
+				continue
+			}
+			name = s
+		}
+		if !filter.Match(filepath.ToSlash(name)) {
+			debugf("skip filtered: %s", name)
+			continue
+		}
+		if isSymlink(extractor, i) {
+			switch cfg.Symlinks {
+			case "skip":
+				debugf("skip symlink: %s", name)
+				continue
+			case "materialize":
+				j, err := resolveLink(extractor, files, i)
+				if err != nil {
+					warnf("skip symlink %s: %v", name, err)
+					continue
+				}
+				linkSources[i] = j
+			case "metadata":
+				target, err := readLinkTarget(extractor, i)
+				if err != nil {
+					return fmt.Errorf("read link(%s): %w", name, err)
+				}
+				linkTargets[i] = target
+			}
+		}
+		if _, meta := linkTargets[i]; !extractor.IsDir(i) && !meta && extractor.SizeKnown(source(i)) {
+			if size := extractor.FileSize(source(i)); size < cfg.MinSize || cfg.MaxSize > 0 && size > cfg.MaxSize {
+				debugf("skip by size: %s (%s)", name, FormatBytes(size))
+				continue
+			}
+		}
+		if topDir != "" {
+			name = strings.TrimPrefix(name, topDir)
+			if name != "" {
+				name = name[1:]
+			}
+		}
+		if cfg.StripComponents > 0 {
+			if name = stripComponents(name, cfg.StripComponents); name == "" {
+				continue
+			}
+		}
+		if len(cfg.Renames) > 0 {
+			s := filepath.FromSlash(applyRenames(cfg.Renames, filepath.ToSlash(name)))
+			if isUnsafePath(s) {
+				s = rebasePath(s)
+			}
+			if s == "" {
+				debugf("skip renamed to empty: %s", name)
+				continue
+			}
+			name = s
+		}
+		if normForm != nil {
+			name = normForm(name)
+		}
+		if s := sanitizeName(name, cfg.Sanitize); s != name {
+			if s == "" {
+				warnf("skip invalid name: %q", extractor.FileName(i))
+				continue
+			}
+			infof("rename %q -> %q", name, s)
+			name = s
+			sanitized[i] = true
+		}
+		names[i] = filepath.Join(archiveName, name)
+	}
+	if len(unsafe) > 0 {
+		return fmt.Errorf("unsafe paths: %s", strings.Join(unsafe, ", "))
+	}
+	if len(cfg.Only) > 0 && !slices.ContainsFunc(names, func(name string) bool { return name != "" }) {
+		return fmt.Errorf("%w: no entries match Only: %s", ErrUsage, strings.Join(cfg.Only, ", "))
+	}
+	if err := resolveDuplicates(extractor, names, cfg.Duplicates); err != nil {
+		return fmt.Errorf("duplicate entries: %w", err)
+	}
+	if cfg.Flatten {
+		if err := flattenNames(extractor, names, archiveName, cfg.FlattenCollisions); err != nil {
+			return fmt.Errorf("flatten collisions: %w", err)
+		}
+	}
+
+	var emptyDirs []string
+	if cfg.KeepEmptyDirs {
+		parents := map[string]bool{}
+		for i, name := range names {
+			if name == "" || extractor.IsDir(i) {
+				continue
+			}
+			for d := filepath.Dir(name); d != "." && !parents[d]; d = filepath.Dir(d) {
+				parents[d] = true
+			}
+		}
+		for i, name := range names {
+			if name != "" && extractor.IsDir(i) && !parents[name] {
+				emptyDirs = append(emptyDirs, name)
+			}
+		}
+	}
+	switch {
+	case cfg.Shard != nil:
+		if n := extractor.Files(); n != cfg.Shard.Entries {
+			return fmt.Errorf("%w: the archive has %d entries instead of %d of the spec", ErrUsage, n, cfg.Shard.Entries)
+		}
+		for i := range names {
+			if (i < cfg.Shard.Start || i >= cfg.Shard.End) && !extractor.IsDir(i) {
+				names[i] = ""
+			}
+		}
+	case sharded:
+		shardEntries(extractor, names, cfg.ShardIndex, cfg.ShardCount)
+	}
+	if sharded && cfg.ShardIndex != 0 || cas != nil {
+		emptyDirs = nil
+	}
+	for i, name := range names {
+		if name == "" || !sanitized[i] || extractor.IsDir(i) {
+			continue
+		}
+		r := Rename{Entry: extractor.FileName(i), Path: entryName(name)}
+		if cas == nil {
+			r.Object = "gs://" + path.Join(dest.Hostname(), objectName(name))
+		}
+		report.Renamed = append(report.Renamed, r)
+	}
+
+	var largestFile string
+	var largestSize uint64
+	var totalSize uint64
+	// knownCount is the number of the entries whose sizes are counted in totalSize.
+	filesCount, knownCount := 0, 0
+	for i, name := range names {
+		if name == "" || extractor.IsDir(i) {
+			continue
+		}
+		if err := checkRatio(source(i)); err != nil && cfg.OnBomb == "abort" {
+			return fmt.Errorf("%s: %w", extractor.FileName(i), err)
+		}
+
+		filesCount++
+		if !extractor.SizeKnown(source(i)) {
+			// the placeholder is not the size, which is checked while extracting.
+			continue
+		}
+		knownCount++
+		size := extractor.FileSize(source(i))
+		totalSize += size
+		if largestSize < size {
+			largestFile = extractor.FileName(i)
+			largestSize = size
+		}
+	}
+	if cfg.MaxTotalSize > 0 && cfg.MaxTotalSize < totalSize {
+		return fmt.Errorf("%w: %s > %s", errTotalSizeExceeded, FormatBytes(totalSize), FormatBytes(cfg.MaxTotalSize))
+	}
+	if free, ok := diskFree(workDir); ok && free < diskLimit {
+		warnf("disk limit %s is larger than free space %s of the temporary directory, using the free space", FormatBytes(diskLimit), FormatBytes(free))
+		diskLimit = free
+	}
+	diskLimit = cfg.Budget.diskLimit(diskLimit)
+	if diskLimit < largestSize {
+		return fmt.Errorf("%w: the largest entry %s needs %s, but the disk limit is %s", ErrNoSpace, largestFile, FormatBytes(largestSize), FormatBytes(diskLimit))
+	}
+	if knownCount > 0 {
+		// the uploads would wait for each other on the disk, so the job fails before extracting anything.
+		n := min(cfg.Concurrency, knownCount)
+		avg := totalSize / uint64(knownCount)
+		if need := avg * uint64(n); need > diskLimit {
+			return fmt.Errorf("%w: the disk limit %s cannot hold %d uploads of the average size %s, %s is required (lower Concurrency or raise DiskLimit)", ErrNoSpace, FormatBytes(diskLimit), n, FormatBytes(avg), FormatBytes(need))
+		}
+	}
+
+	debugf("files: %d", filesCount)
+	// the other uploads would fail the precondition of the generation of an object.
+	if conds != nil && !conds.DoesNotExist && filesCount > 1 {
+		return fmt.Errorf("%w: IfGenerationMatch %d requires a single file, but %d files are extracted", ErrUsage, *cfg.IfGenerationMatch, filesCount)
+	}
+
+	if cfg.DryRun {
+		var cost Cost
+		for i, name := range names {
+			if name == "" || extractor.IsDir(i) {
+				continue
+			}
+			class := cfg.StorageClass
+			if c := applyAttrsRules(attrsRules, entryName(name)).StorageClass; c != "" {
+				class = c
+			}
+			var size int64
+			if extractor.SizeKnown(source(i)) {
+				size = int64(extractor.FileSize(source(i)))
+			}
+			ops := int64(1)
+			if split(size) {
+				// the parts and the parts manifest.
+				ops = numParts(size, int64(cfg.SplitSize)) + 1
+			}
+			cost.add(class, ops, size)
+		}
+		cost.add("", int64(len(emptyDirs)), 0)
+		if preserved != nil && (!sharded || cfg.ShardIndex == 0) {
+			cost.add("", 1, archiveSize)
+		}
+		if cfg.SuccessMarker != "" {
+			cost.add("", 1, 0)
+		}
+		st.setCost(cost)
+		logEvent(slog.LevelInfo, []slog.Attr{
+			slog.String("event", "dry_run"),
+			slog.Int("files", filesCount),
+			slog.Uint64("bytes", totalSize),
+			slog.Int64("class_a_ops", cost.ClassAOps),
+			slog.Float64("class_a_usd", cost.ClassA),
+			slog.Float64("storage_usd_per_month", cost.Storage),
+		}, "dry run: %d files, %s, estimated %d class A ops $%.2f, storage $%.2f/month", filesCount, FormatBytes(totalSize), cost.ClassAOps, cost.ClassA, cost.Storage)
+		return nil
+	}
+
+	if cfg.Sync && (cfg.SyncBy != "checksum" || cfg.DeleteExtraneous) && !local {
+		existing, err = listObjects(ctx, bucket, dt.Prefix())
+		if err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+		debugf("sync: %d objects under gs://%s/%s", len(existing), dest.Hostname(), dt.Prefix())
+	}
+
+	// keep the parent context, the group context is canceled by Wait.
+	baseCtx := ctx
+	uploadGroup, ctx := errgroup.WithContext(ctx)
+	extractCtx, cancelExtract := context.WithCancel(ctx)
+	defer cancelExtract()
+	defer context.AfterFunc(interruptCtx, cancelExtract)()
+	uploadGroup.SetLimit(cfg.Concurrency + 1)
+	diskSem := newDiskSemaphore(diskLimit, cfg.Budget)
+	defer func() {
+		st.disk(diskSem.Waited(), diskSem.Peak(), int64(diskLimit))
+	}()
+	prog.filesTotal.Store(int64(filesCount))
+	prog.bytesTotal.Store(totalSize)
+	var sinks []func(ProgressRecord)
+	if cfg.Progress != nil {
+		sinks = append(sinks, progressWriter(cfg.Progress))
+	}
+	if cfg.ProgressFunc != nil {
+		sinks = append(sinks, cfg.ProgressFunc)
+	}
+	if len(sinks) > 0 {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		defer func() {
+			close(stop)
+			<-done
+		}()
+		go func() {
+			defer close(done)
+			prog.report(func(r ProgressRecord) {
+				for _, sink := range sinks {
+					sink(r)
+				}
+			}, cfg.ProgressInterval, stop)
+		}()
+	}
+
+	uploadJobCh := make(chan uploadJob, filesCount)
+	expected := map[string]expectedObject{}
+
+	if cp != nil && cfg.CheckpointInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			t := time.NewTicker(cfg.CheckpointInterval)
+			defer t.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-t.C:
+				}
+				if err := cp.Flush(baseCtx); err != nil {
+					warnf("failed to flush checkpoint: %v", err)
+				}
+			}
+		}()
+	}
+
+	// the dispatcher drains the extracted files until the extraction closes the channel, also after Stop,
+	// and stops early only if ctx is canceled.
+	uploadGroup.Go(func() error {
+		for {
+			var job uploadJob
+			select {
+			case <-ctx.Done():
+				return nil
+			case j, ok := <-uploadJobCh:
+				if !ok {
+					return nil
+				}
+				job = j
+			}
+			uploadGroup.Go(func() error {
+				defer diskSem.Release(job.size)
+				defer prog.disk.Add(-job.size)
+				defer prog.settled.Add(job.declared)
+				if job.source != nil {
+					defer close(job.source.done)
+				}
+				defer func() {
+					if local || job.copyOf != nil {
+						return
+					}
+					err := os.Remove(filepath.Join(workDir, job.name))
+					if err != nil {
+						warnf("failed to remove temp file: %v", err)
+					}
+				}()
+				if job.copyOf != nil {
+					// the budget is not held while waiting, for the upload of the source.
+					select {
+					case <-job.copyOf.done:
+					case <-ctx.Done():
+						return nil
+					}
+				}
+				if err := cfg.Budget.acquireUpload(ctx, int64(cfg.ChunkSize)); err != nil {
+					// ctx is done.
+					return nil
+				}
+				prog.inFlight.Add(1)
+				err := upload(ctx, job)
+				prog.inFlight.Add(-1)
+				cfg.Budget.releaseUpload(int64(cfg.ChunkSize))
+				if err != nil {
+					if !cfg.ContinueOnError || ctx.Err() != nil {
+						return err
+					}
+					logEvent(slog.LevelError, []slog.Attr{
+						slog.String("event", "error"),
+						slog.String("stage", "upload"),
+						slog.String("entry", job.entry),
+						slog.String("error", err.Error()),
+					}, "failed to upload %s: %v", job.entry, err)
+					fl.Add(job.entry, "upload", err)
+				}
+				return nil
+			})
+		}
+	})
+
+	// discard removes the temporary file of an entry which is not uploaded.
+	discard := func(name string, size int64) {
+		if err := os.Remove(filepath.Join(workDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			warnf("failed to remove temp file: %v", err)
+		}
+		diskSem.Release(size)
+		prog.disk.Add(-size)
+	}
+
+	// dedupeSources are the first of the identical entries by Dedupe.
+	dedupeSources := map[dedupeKey]*dedupeSource{}
+	// dedupeKeyOf returns the key of the entry if it can be copied from an identical one, whose content is not
+	// changed by TransformCmd and which is not decided by Filter with its content.
+	dedupeKeyOf := func(i int, name string) (dedupeKey, bool) {
+		if _, link := linkTargets[i]; !cfg.Dedupe || link || cfg.Filter != nil || !extractor.SizeKnown(source(i)) {
+			return dedupeKey{}, false
+		}
+		if cfg.TransformCmd != "" && (len(cfg.TransformInclude) == 0 || matchAny(cfg.TransformInclude, nil, entryName(name))) {
+			return dedupeKey{}, false
+		}
+		crc, ok := entryCRC32(extractor, source(i))
+		size := extractor.FileSize(source(i))
+		if !ok || size == 0 || split(int64(size)) {
+			return dedupeKey{}, false
+		}
+		return dedupeKey{crc: crc, size: size, compressedSize: extractor.CompressedSize(source(i))}, true
+	}
+
+	var extracted int64
+	prog.setStage("extract")
+	extractStart := time.Now()
+FILES:
+	for i := 0; i < extractor.Files(); i++ {
+		select {
+		case <-extractCtx.Done():
+			break FILES
+		default:
+		}
+		name := names[i]
+		if name == "" {
+			continue
+		}
+		entry := extractor.FileName(i)
+		if extractor.IsDir(i) {
+			if err := os.MkdirAll(filepath.Join(workDir, name), 0700); err != nil {
+				return fmt.Errorf("mkdir: %w", err)
+			}
+			continue
+		}
+		declared := extractor.FileSize(source(i))
+		size := int64(declared)
+		if _, ok := linkTargets[i]; ok {
+			size = 0
+		}
+		if !extractor.SizeKnown(source(i)) {
+			if size > int64(diskLimit) {
+				size = int64(diskLimit)
+			}
+			// the progress settles only the sizes counted in bytesTotal.
+			declared = 0
+		}
+		// the entries by Layout cas are extracted again to be listed in the manifest, but not uploaded again.
+		entryCRC := entryChecksum(extractor, source(i))
+		if cp != nil && cas == nil {
+			if crc, ok := cp.Lookup(name, entryCRC); ok {
+				if cfg.Verify && !split(size) {
+					expected[objectName(name)] = expectedObject{size: size, crc: crc, gzip: extEncoding(name) != ""}
+				}
+				prog.settled.Add(declared)
+				continue
+			}
+		}
+		checksum := entryCRC
+		if _, ok := linkTargets[i]; ok || (cfg.TransformCmd != "" && (len(cfg.TransformInclude) == 0 || matchAny(cfg.TransformInclude, nil, entryName(name)))) {
+			// the object of a symlink or a transformed file is not the content of the entry.
+			checksum = ""
+		}
+		if attrs, ok := existing[objectName(name)]; ok && (cfg.SyncBy == "mtime" && unchangedSince(attrs, extractor.ModTime(source(i)), declared, extractor.SizeKnown(source(i))) ||
+			cfg.SyncBy == "crc32" && unchangedEntry(attrs, checksum, declared)) {
+			debugf("skip unchanged: gs://%s/%s", attrs.Bucket, attrs.Name)
+			if mf != nil {
+				mf.Add(entry, attrs)
+			}
+			if bq != nil {
+				bq.Add(entry, attrs)
+			}
+			if cfg.Verify {
+				expected[attrs.Name] = expectedObject{size: attrs.Size, crc: attrs.CRC32C, gzip: attrs.ContentEncoding != ""}
+			}
+			prog.settled.Add(declared)
+			continue
+		}
+		if err := checkRatio(source(i)); err != nil {
+			// OnBomb abort has failed before extracting the entries, so this is OnBomb skip.
+			warnf("skip %s: %v", entry, err)
+			skipped++
+			prog.settled.Add(declared)
+			continue
+		}
+		key, dedupe := dedupeKeyOf(i, name)
+		if s, ok := dedupeSources[key]; ok && dedupe {
+			debugf("copy identical entry: %s <- %s", entry, s.entry)
+			// the copies are checked by the server, which keeps their checksums.
+			uploadJobCh <- uploadJob{name: name, entry: entry, declared: declared, checksum: checksum, entryCRC: entryCRC, copyOf: s}
+			continue
+		}
+		if err := diskSem.Acquire(extractCtx, size); err != nil {
+			if interrupted() {
+				break FILES
+			}
+			return fmt.Errorf("acquire disk sem: %w", err)
+		}
+		prog.disk.Add(size)
+
+		limit := int64(-1)
+		if cfg.MaxTotalSize > 0 {
+			limit = int64(cfg.MaxTotalSize) - extracted
+		}
+		var written int64
+		var crc uint32
+		if _, ok := linkTargets[i]; ok {
+			err = writeEmpty(workDir, name)
+		} else {
+			ectx, span := tracer.Start(ctx, "extract", trace.WithAttributes(attribute.String("entry", entry)))
+			writeStart := time.Now()
+			written, crc, err = writeTemporary(ectx, extractor, source(i), name, workDir, limit)
+			st.work("extract", written, time.Since(writeStart))
+			span.SetAttributes(attribute.Int64("bytes", written))
+			endSpan(span, err)
+		}
+		extracted += written
+		if err != nil {
+			skip := cfg.ContinueOnError || (cfg.OnBomb == "skip" && errors.Is(err, errBomb))
+			if !skip || errors.Is(err, errTotalSizeExceeded) {
+				return fmt.Errorf("write temp: %w", err)
+			}
+			if cfg.OnBomb == "skip" && errors.Is(err, errBomb) {
+				warnf("skip %s: %v", entry, err)
+				skipped++
+			} else {
+				logEvent(slog.LevelError, []slog.Attr{
+					slog.String("event", "error"),
+					slog.String("stage", "extract"),
+					slog.String("entry", entry),
+					slog.String("error", err.Error()),
+				}, "failed to extract %s: %v", entry, err)
+				fl.Add(entry, "extract", err)
+			}
+			if err := os.Remove(filepath.Join(workDir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				warnf("failed to remove temp file: %v", err)
+			}
+			diskSem.Release(size)
+			prog.disk.Add(-size)
+			prog.settled.Add(declared)
+			continue
+		}
+		if written != size {
+			// the declared size was not reliable, so the actual disk usage is accounted.
+			if written > int64(diskLimit) {
+				return fmt.Errorf("%w(%s): %s", ErrNoSpace, entry, FormatBytes(uint64(written)))
+			}
+			if err := diskSem.Resize(extractCtx, size, written); err != nil {
+				if interrupted() {
+					break FILES
+				}
+				return fmt.Errorf("acquire disk sem: %w", err)
+			}
+			prog.disk.Add(written - size)
+			size = written
+		}
+		if cfg.Filter != nil && linkTargets[i] == "" {
+			switch d, err := filterEntry(ctx, cfg.Filter, entryName(name), filepath.Join(workDir, name), size); d {
+			case FilterAllow:
+			case FilterSkip:
+				discard(name, size)
+				prog.settled.Add(declared)
+				debugf("skip %s: filter", entry)
+				continue
+			default:
+				discard(name, size)
+				prog.settled.Add(declared)
+				if err == nil {
+					err = errors.New("rejected by filter")
+				}
+				if !cfg.ContinueOnError {
+					return fmt.Errorf("filter(%s): %w", entry, err)
+				}
+				logEvent(slog.LevelError, []slog.Attr{
+					slog.String("event", "error"),
+					slog.String("stage", "filter"),
+					slog.String("entry", entry),
+					slog.String("error", err.Error()),
+				}, "failed to filter %s: %v", entry, err)
+				fl.Add(entry, "filter", err)
+				continue
+			}
+		}
+		if cfg.TransformCmd != "" && linkTargets[i] == "" && (len(cfg.TransformInclude) == 0 || matchAny(cfg.TransformInclude, nil, entryName(name))) {
+			transformed, c, err := transformFile(ctx, cfg.TransformCmd, filepath.Join(workDir, name), entry)
+			if err != nil {
+				if !cfg.ContinueOnError {
+					return fmt.Errorf("transform(%s): %w", entry, err)
+				}
+				logEvent(slog.LevelError, []slog.Attr{
+					slog.String("event", "error"),
+					slog.String("stage", "transform"),
+					slog.String("entry", entry),
+					slog.String("error", err.Error()),
+				}, "failed to transform %s: %v", entry, err)
+				fl.Add(entry, "transform", err)
+				discard(name, size)
+				prog.settled.Add(declared)
+				continue
+			}
+			if transformed > int64(diskLimit) {
+				return fmt.Errorf("%w(%s): %s", ErrNoSpace, entry, FormatBytes(uint64(transformed)))
+			}
+			if err := diskSem.Resize(extractCtx, size, transformed); err != nil {
+				if interrupted() {
+					break FILES
+				}
+				return fmt.Errorf("acquire disk sem: %w", err)
+			}
+			prog.disk.Add(transformed - size)
+			size, crc = transformed, c
+		}
+		var enc, raw string
+		if !split(size) {
+			enc, raw = contentEncoding(name, size)
+		}
+		job := uploadJob{name: name, entry: entry, size: size, declared: declared, crc: crc, checksum: checksum, entryCRC: entryCRC, linkTarget: linkTargets[i], encoding: enc, rawEncoding: raw}
+		if dedupe {
+			job.source = newDedupeSource(entry)
+			dedupeSources[key] = job.source
+		}
+		uploadJobCh <- job
+		// the parts and the objects by Layout cas are verified by their checksums on upload.
+		if cfg.Verify && !split(size) && cas == nil {
+			expected[objectName(name)] = expectedObject{size: size, crc: crc, gzip: enc != ""}
+		}
+	}
+	close(uploadJobCh)
+	st.stage("extract", extractStart)
+
+	err = uploadGroup.Wait()
+	st.stage("upload", uploadsStart)
+	prog.setStage("finalize")
+	finalizeStart := time.Now()
+	defer st.stage("finalize", finalizeStart)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpload, err)
+	}
+	if baseCtx.Err() != nil {
+		return fmt.Errorf("uploads: %w", context.Cause(baseCtx))
+	}
+	if interrupted() {
+		return fmt.Errorf("uploads: %w", ErrInterrupted)
+	}
+	logEvent(slog.LevelInfo, []slog.Attr{
+		slog.String("event", "total"),
+		slog.Int64("files", count.Load()),
+		slog.Duration("duration", time.Since(uploadsStart)),
+	}, "total: %s", time.Now().Sub(uploadsStart))
+
+	if !local {
+		for _, d := range emptyDirs {
+			st.gcsOp("write", 1)
+			st.charge("", 1, 0)
+			if err := writeMarker(baseCtx, object(objectName(d)+"/"), cfg.KMSKey); err != nil {
+				return fmt.Errorf("write dir placeholder: %w", err)
+			}
+			debugf("-> gs://%s/", path.Join(dest.Hostname(), objectName(d)))
+		}
+	}
+
+	if cas != nil && !local {
+		name := casManifestName(casRoot, archiveName)
+		if sharded {
+			name = shardPath(name, cfg.ShardIndex)
+		}
+		st.gcsOp("write", 1)
+		st.charge("", 1, 0)
+		if err := cas.Write(baseCtx, gcs, "gs://"+path.Join(dest.Hostname(), name)); err != nil {
+			return fmt.Errorf("write cas manifest: %w", err)
+		}
+		logEvent(slog.LevelInfo, []slog.Attr{
+			slog.String("event", "cas"),
+			slog.String("manifest", "gs://"+path.Join(dest.Hostname(), name)),
+			slog.Int64("files", count.Load()),
+			slog.Int64("deduplicated", casDeduped.Load()),
+		}, "cas: %d files, %d deduplicated -> gs://%s", count.Load(), casDeduped.Load(), path.Join(dest.Hostname(), name))
+	}
+	if mf != nil {
+		if err := mf.Write(baseCtx, gcs, cfg.Manifest); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+	}
+	if bq != nil {
+		if err := bq.Close(); err != nil {
+			return fmt.Errorf("bq manifest: %w", err)
+		}
+	}
+
+	if cfg.ErrorReport != "" {
+		if err := fl.Write(baseCtx, gcs, cfg.ErrorReport); err != nil {
+			return fmt.Errorf("write error report: %w", err)
+		}
+	}
+	if n := fl.Len(); n > 0 {
+		return fmt.Errorf("%d entries: %w", n, ErrPartialFailure)
+	}
+
+	if cfg.Verify && !local {
+		if err := verifyObjects(baseCtx, bucket, dt.Prefix(), expected); err != nil {
+			return fmt.Errorf("%w: %w", ErrVerify, err)
+		}
+		debugf("verified: %d objects", len(expected))
+	}
+
+	if cfg.DeleteExtraneous && existing != nil {
+		keep := map[string]bool{dt.Object(cfg.SuccessMarker): cfg.SuccessMarker != ""}
+		for i, name := range names {
+			switch {
+			case name == "":
+			case extractor.IsDir(i):
+				keep[objectName(name)+"/"] = true
+			default:
+				keep[objectName(name)] = true
+			}
+		}
+		// the outputs of the job may be written under the destination prefix.
+		outputs := []string{cfg.Manifest, cfg.ErrorReport, cfg.Checkpoint}
+		if preserved != nil {
+			outputs = append(outputs, preserved.String())
+		}
+		for _, p := range outputs {
+			if u, err := url.Parse(p); err == nil && u.Scheme == "gs" && u.Host == dest.Hostname() {
+				keep[strings.TrimPrefix(u.Path, "/")] = true
+			}
+		}
+		// the parts and the parts manifests of the split entries.
+		for name := range existing {
+			if base, ok := partsBase(name); ok && keep[base] {
+				keep[name] = true
+			}
+		}
+		n, err := deleteExtraneous(baseCtx, bucket, existing, keep, cfg.Concurrency, st)
+		report.Deleted = n
+		if err != nil {
+			return fmt.Errorf("delete extraneous: %w", err)
+		}
+		logEvent(slog.LevelInfo, []slog.Attr{
+			slog.String("event", "delete_extraneous"),
+			slog.Int64("deleted", n),
+		}, "deleted %d extraneous objects under gs://%s/%s", n, dest.Hostname(), dt.Prefix())
+	}
+
+	if preserved != nil && (!sharded || cfg.ShardIndex == 0) && !local {
+		st.gcsOp("copy", 1)
+		st.charge("", 1, archiveSize)
+		if err := copyArchive(baseCtx, gcs, src, preserved, archiveGeneration, csek, cfg.KMSKey, cfg.RetryTimeout); err != nil {
+			return fmt.Errorf("preserve archive: %w", err)
+		}
+		logEvent(slog.LevelInfo, []slog.Attr{
+			slog.String("event", "preserve_archive"),
+			slog.String("src", src.String()),
+			slog.String("dest", preserved.String()),
+			slog.Int64("bytes", archiveSize),
+		}, "preserve archive: -> %s", preserved.String())
+	}
+
+	if cfg.SuccessMarker != "" && !local {
+		name := dt.Object(cfg.SuccessMarker)
+		st.gcsOp("write", 1)
+		st.charge("", 1, 0)
+		if err := writeMarker(baseCtx, object(name), cfg.KMSKey); err != nil {
+			return fmt.Errorf("write success marker: %w", err)
+		}
+		debugf("-> gs://%s", path.Join(dest.Hostname(), name))
+	}
+	return nil
+}
+
+func parseGSURL(s string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return nil, fmt.Errorf("parse uri: %w", err)
+	}
+	if local {
+		return u, nil
+	}
+	if u.Scheme != "gs" {
+		return nil, fmt.Errorf("must start with gs://: %s", u.Scheme)
+	}
+	return u, nil
+}
+
+// download downloads the source archive into workDir, and returns its path and the generation.
+// Opening the archive and each read with its retries are bounded by timeout if positive.
+func download(ctx context.Context, gcs *storage.Client, workDir string, src *url.URL, key []byte, timeout time.Duration) (string, int64, error) {
+	if local {
+		return strings.TrimPrefix(src.Path, "/"), 0, nil
+	}
+	o := gcs.Bucket(src.Hostname()).Object(src.Path[1:])
+	if key != nil {
+		o = o.Key(key)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var t *time.Timer
+	if timeout > 0 {
+		t = time.AfterFunc(timeout, cancel)
+	}
+	r, err := o.NewReader(ctx)
+	if t != nil {
+		t.Stop()
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			err = fmt.Errorf("%w: %w", ErrSourceNotFound, err)
+		}
+		return "", 0, fmt.Errorf("src reader: %w", err)
+	}
+	defer r.Close()
+	var sr io.Reader = r
+	if timeout > 0 {
+		sr = &stallReader{r: r, timeout: timeout, cancel: cancel}
+	}
+	p := filepath.Join(workDir, path.Base(src.Path))
+	f, err := os.Create(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("create tmp file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, sr); err != nil {
+		return "", 0, fmt.Errorf("copy: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, fmt.Errorf("close tmp file: %w", err)
+	}
+	// keep the mtime of the source object for Config.CustomTime source-mtime.
+	if mtime := r.Attrs.LastModified; !mtime.IsZero() {
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			return "", 0, fmt.Errorf("chtimes: %w", err)
+		}
+	}
+	return p, r.Attrs.Generation, nil
+}
+
+type uploadJob struct {
+	name  string // path relative to the work dir
+	entry string // name in the archive
+	size  int64
+	// declared is the size in the archive, which is counted as done by the progress after the upload.
+	declared uint64
+	crc      uint32
+	// checksum is the CRC-32 of the entry recorded in the metadata of the object by setEntryChecksum.
+	checksum string
+	// entryCRC is the CRC-32 of the entry recorded by the checkpoint, which is also set for transformed files.
+	entryCRC   string
+	linkTarget string
+	encoding   string // Content-Encoding, empty if uploaded as is
+	// rawEncoding is the Content-Encoding of the file which is already compressed.
+	rawEncoding string
+	// source is set if the object is copied to the identical entries by Config.Dedupe,
+	// and copyOf is set instead of the file if the entry is one of them.
+	source *dedupeSource
+	copyOf *dedupeSource
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errTotalSizeExceeded = errors.New("total size limit exceeded")
+	errBomb              = errors.New("decompression bomb")
+)
+
+// writeTemporary extracts the i-th entry into workDir.
+// It fails with errBomb if the entry expands beyond its declared size if it is known,
+// and with errTotalSizeExceeded if it is larger than limit, unless limit is negative.
+func writeTemporary(ctx context.Context, e Extractor, i int, name, workDir string, limit int64) (int64, uint32, error) {
+	rc, err := e.Open(i)
+	if err != nil {
+		return 0, 0, fmt.Errorf("open zip entry(%s): %w", name, err)
+	}
+	defer rc.Close()
+	declared := int64(e.FileSize(i))
+	if !e.SizeKnown(i) {
+		declared = -1
+	}
+	var r io.Reader = rc
+	switch {
+	case declared >= 0 && (limit < 0 || declared < limit):
+		r = io.LimitReader(rc, declared+1)
+	case limit >= 0:
+		r = io.LimitReader(rc, limit+1)
+	}
+
+	tmpFile := filepath.Join(workDir, name)
+	f, err := os.Create(tmpFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(tmpFile), 0700); err != nil {
+			return 0, 0, fmt.Errorf("mkdir all: %w", err)
+		}
+		f, err = os.Create(tmpFile)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("create: %w", err)
+	}
+	defer f.Close()
+
+	h := crc32.New(crc32cTable)
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return n, 0, fmt.Errorf("copy: %w", err)
+	}
+	if declared >= 0 && n > declared {
+		return n, 0, fmt.Errorf("%w(%s): larger than declared size %d", errBomb, name, declared)
+	}
+	if limit >= 0 && n > limit {
+		return n, 0, fmt.Errorf("%w(%s)", errTotalSizeExceeded, name)
+	}
+	if err := f.Close(); err != nil {
+		return n, 0, fmt.Errorf("close: %w", err)
+	}
+	return n, h.Sum32(), nil
+}
+
+func writeEmpty(workDir, name string) error {
+	tmpFile := filepath.Join(workDir, name)
+	if err := os.MkdirAll(filepath.Dir(tmpFile), 0700); err != nil {
+		return fmt.Errorf("mkdir all: %w", err)
+	}
+	if err := os.WriteFile(tmpFile, nil, 0600); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	return nil
+}
+
+func writeMarker(ctx context.Context, o *storage.ObjectHandle, kmsKey string) error {
+	w := o.Retryer(storage.WithPolicy(storage.RetryAlways)).NewWriter(ctx)
+	w.ContentType = "text/plain"
+	w.KMSKeyName = kmsKey
+	return w.Close()
+}
+
+// sameObject reports whether attrs describes an object uploaded from the file.
+// compressed objects only have to exist with the encoding because their stored bytes differ from the file.
+func sameObject(attrs *storage.ObjectAttrs, f *os.File, crc uint32, encoding string) bool {
+	if encoding != "" {
+		return attrs.ContentEncoding == encoding
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return attrs.Size == fi.Size() && attrs.CRC32C == crc
+}
+
+func isIgnoreMeta(name string) bool {
+	rest := name
+	sep := string(os.PathSeparator)
+	for rest != "" {
+		n, after, found := strings.Cut(rest, sep)
+		if !found {
+			return n == ".DS_Store" || n == "Thumbs.db" || n == "__MACOSX"
+		}
+		rest = after
+		if n == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+func trimExt(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
@@ -0,0 +1,39 @@
+package gcsunzip
+
+import "context"
+
+// FilterDecision is the result of Config.Filter.
+type FilterDecision int
+
+const (
+	// FilterAllow uploads the entry.
+	FilterAllow FilterDecision = iota
+	// FilterSkip skips the entry without an error.
+	FilterSkip
+	// FilterFail fails the entry, which aborts the job unless ContinueOnError.
+	FilterFail
+)
+
+// FilterEntry is an extracted entry passed to Config.Filter.
+type FilterEntry struct {
+	// Name is the slash-separated entry name under the archive root.
+	Name string
+	Size int64
+	// ContentType is sniffed from the first 512 bytes by http.DetectContentType.
+	ContentType string
+	// Path is the extracted file, which must not be modified.
+	Path string
+}
+
+// filterEntry calls filter for the extracted file p, and an error means FilterFail.
+func filterEntry(ctx context.Context, filter func(context.Context, FilterEntry) (FilterDecision, error), name, p string, size int64) (FilterDecision, error) {
+	typ, err := sniffContentType(p)
+	if err != nil {
+		return FilterFail, err
+	}
+	d, err := filter(ctx, FilterEntry{Name: name, Size: size, ContentType: typ, Path: p})
+	if err != nil {
+		return FilterFail, err
+	}
+	return d, nil
+}
@@ -0,0 +1,136 @@
+package gcsunzip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Index is the central directory of a zip archive written by WriteIndex, with which an entry can be read
+// by a single range request of [DataOffset, DataOffset+CompressedSize) of the archive of the generation.
+type Index struct {
+	Archive    string       `json:"archive"`
+	Generation int64        `json:"generation,omitempty"`
+	Size       int64        `json:"size"`
+	Entries    []IndexEntry `json:"entries"`
+}
+
+// IndexEntry is an entry of Index. Method is the compression method of the data, e.g. store or deflate.
+type IndexEntry struct {
+	Name           string    `json:"name"`
+	DataOffset     int64     `json:"data_offset"`
+	CompressedSize uint64    `json:"compressed_size"`
+	Size           uint64    `json:"size"`
+	CRC32          uint32    `json:"crc32"`
+	Method         string    `json:"method"`
+	Modified       time.Time `json:"modified"`
+	Dir            bool      `json:"dir,omitempty"`
+}
+
+// IndexConfig configures WriteIndex.
+type IndexConfig struct {
+	// Src is the gs:// URL of the zip archive.
+	Src string
+	// Dest is a local file or gs:// object to write the index to (default <Src>.index.json).
+	Dest string
+	// Client is used for GCS requests. A client is created if nil.
+	Client *storage.Client
+	// Encoding is the fallback encoding of entry names which are not valid UTF-8 (default shiftjis).
+	Encoding string
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// EncryptionKey is the AES-256 customer-supplied key of the source.
+	EncryptionKey []byte
+}
+
+// WriteIndex writes the Index of a zip archive on GCS as JSON, reading its directory and the local headers
+// of the entries with range requests. Undecodable names are percent-encoded as List.
+func WriteIndex(ctx context.Context, cfg IndexConfig) (*Index, error) {
+	if cfg.Encoding == "" {
+		cfg.Encoding = "shiftjis"
+	}
+	if cfg.Dest == "" {
+		cfg.Dest = cfg.Src + ".index.json"
+	}
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	nameEncoding, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Encoding: %w", ErrUsage, err)
+	}
+	if cfg.EncryptionKey != nil && len(cfg.EncryptionKey) != 32 {
+		return nil, fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+	}
+
+	gcs := cfg.Client
+	if gcs == nil && !local {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	ra, size, err := openSource(ctx, gcs, src, cfg.EncryptionKey, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	extractor, err := newExtractor(ra, size, src.Path, ExtractorOptions{
+		OldWindows:     cfg.OldWindows,
+		Encoding:       nameEncoding,
+		HexUndecodable: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extractor: %w", err)
+	}
+	ze, ok := extractor.(*zipExtractor)
+	if !ok {
+		return nil, fmt.Errorf("%w: index needs a zip archive: %s", ErrUnsupportedFormat, path.Ext(src.Path))
+	}
+
+	start := time.Now()
+	index := &Index{Archive: cfg.Src, Size: size, Entries: make([]IndexEntry, ze.Files())}
+	if r, ok := ra.(*remoteReaderAt); ok {
+		index.Generation = r.Generation()
+	}
+	for i := range index.Entries {
+		f := ze.zr.File[i]
+		// the offset of the data depends on the local header, which can differ from the central directory.
+		off, err := f.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("data offset(%s): %w", f.Name, err)
+		}
+		index.Entries[i] = IndexEntry{
+			Name:           filepath.ToSlash(ze.FileName(i)),
+			DataOffset:     off,
+			CompressedSize: ze.CompressedSize(i),
+			Size:           ze.FileSize(i),
+			CRC32:          f.CRC32,
+			Method:         zipMethodName(f.Method),
+			Modified:       ze.ModTime(i),
+			Dir:            ze.IsDir(i),
+		}
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("marshal index: %w", err)
+	}
+	if err := writeLocation(ctx, gcs, cfg.Dest, b, "application/json"); err != nil {
+		return nil, fmt.Errorf("write index: %w", err)
+	}
+	logEvent(slog.LevelInfo, []slog.Attr{
+		slog.String("event", "index"),
+		slog.String("src", cfg.Src),
+		slog.String("dest", cfg.Dest),
+		slog.Int("entries", len(index.Entries)),
+		slog.Duration("duration", time.Since(start)),
+	}, "index: %d entries -> %s: %s", len(index.Entries), cfg.Dest, time.Since(start).Round(time.Millisecond))
+	return index, nil
+}
@@ -0,0 +1,163 @@
+package gcsunzip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/klauspost/compress/zip"
+)
+
+// Entry is an entry of an archive returned by List.
+type Entry struct {
+	Name           string    `json:"name"`
+	Size           uint64    `json:"size"`
+	CompressedSize uint64    `json:"compressed_size"`
+	Method         string    `json:"method,omitempty"`
+	Modified       time.Time `json:"modified"`
+	Dir            bool      `json:"dir,omitempty"`
+}
+
+// ListConfig configures List.
+type ListConfig struct {
+	// Src is the gs:// URL of the archive.
+	Src string
+	// Client is used for GCS requests. A client is created if nil.
+	Client *storage.Client
+	// Encoding is the fallback encoding of entry names which are not valid UTF-8 (default shiftjis).
+	Encoding string
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// EncryptionKey is the AES-256 customer-supplied key of the source.
+	EncryptionKey []byte
+}
+
+// List returns the entries of an archive on GCS, reading only its directory with range requests.
+// Undecodable names are percent-encoded.
+func List(ctx context.Context, cfg ListConfig) ([]Entry, error) {
+	if cfg.Encoding == "" {
+		cfg.Encoding = "shiftjis"
+	}
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	nameEncoding, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Encoding: %w", ErrUsage, err)
+	}
+	if cfg.EncryptionKey != nil && len(cfg.EncryptionKey) != 32 {
+		return nil, fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+	}
+
+	gcs := cfg.Client
+	if gcs == nil && !local {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	ra, size, err := openSource(ctx, gcs, src, cfg.EncryptionKey, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	extractor, err := newExtractor(ra, size, src.Path, ExtractorOptions{
+		OldWindows:     cfg.OldWindows,
+		Encoding:       nameEncoding,
+		HexUndecodable: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extractor: %w", err)
+	}
+
+	entries := make([]Entry, extractor.Files())
+	for i := range entries {
+		entries[i] = Entry{
+			Name:           filepath.ToSlash(extractor.FileName(i)),
+			Size:           extractor.FileSize(i),
+			CompressedSize: extractor.CompressedSize(i),
+			Method:         entryMethod(extractor, i),
+			Modified:       extractor.ModTime(i),
+			Dir:            extractor.IsDir(i),
+		}
+	}
+	return entries, nil
+}
+
+// openSource opens the source archive for random access without downloading it.
+// The generation is the latest if 0.
+func openSource(ctx context.Context, gcs *storage.Client, src *url.URL, key []byte, generation int64, timeout time.Duration) (io.ReaderAt, int64, error) {
+	if local {
+		f, err := os.Open(strings.TrimPrefix(src.Path, "/"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("open: %w", err)
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, 0, fmt.Errorf("stat: %w", err)
+		}
+		return f, fi.Size(), nil
+	}
+	o := gcs.Bucket(src.Hostname()).Object(src.Path[1:])
+	if key != nil {
+		o = o.Key(key)
+	}
+	if generation != 0 {
+		o = o.Generation(generation)
+	}
+	r, err := openRemote(ctx, o, timeout)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			err = fmt.Errorf("%w: %w", ErrSourceNotFound, err)
+		}
+		return nil, 0, fmt.Errorf("open src: %w", err)
+	}
+	return r, r.Size(), nil
+}
+
+// entryCRC32 returns the IEEE CRC-32 of the entry in the archive if it is recorded.
+func entryCRC32(e Extractor, i int) (uint32, bool) {
+	switch e := e.(type) {
+	case *zipExtractor:
+		return e.zr.File[i].CRC32, true
+	case *sevenZipExtractor:
+		f := e.zr.File[i]
+		return f.CRC32, f.CRC32 != 0 || f.UncompressedSize == 0
+	}
+	return 0, false
+}
+
+// entryMethod returns the compression method of the entry if it is known.
+func entryMethod(e Extractor, i int) string {
+	if e, ok := e.(*zipExtractor); ok {
+		return zipMethodName(e.zr.File[i].Method)
+	}
+	return ""
+}
+
+func zipMethodName(m uint16) string {
+	switch m {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	case 12:
+		return "bzip2"
+	case 14:
+		return "lzma"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	}
+	return "method" + strconv.Itoa(int(m))
+}
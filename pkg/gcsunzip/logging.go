@@ -0,0 +1,32 @@
+package gcsunzip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+func logf(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	l := slog.Default()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+func debugf(format string, args ...any) { logf(slog.LevelDebug, format, args...) }
+func infof(format string, args ...any)  { logf(slog.LevelInfo, format, args...) }
+func warnf(format string, args ...any)  { logf(slog.LevelWarn, format, args...) }
+func errorf(format string, args ...any) { logf(slog.LevelError, format, args...) }
+
+// logEvent logs the formatted message with the attributes of the event,
+// which are fields in structured handlers such as slog.JSONHandler.
+func logEvent(level slog.Level, attrs []slog.Attr, format string, args ...any) {
+	ctx := context.Background()
+	l := slog.Default()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.LogAttrs(ctx, level, fmt.Sprintf(format, args...), attrs...)
+}
@@ -0,0 +1,56 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+type manifestEntry struct {
+	Object          string `json:"object"`
+	Entry           string `json:"entry"`
+	Size            int64  `json:"size"`
+	CRC32C          string `json:"crc32c"`
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+}
+
+// manifest collects the uploaded objects and is written as JSON Lines.
+type manifest struct {
+	mu      sync.Mutex
+	entries []manifestEntry
+}
+
+func (m *manifest) Add(entry string, attrs *storage.ObjectAttrs) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, manifestEntry{
+		Object:          "gs://" + attrs.Bucket + "/" + attrs.Name,
+		Entry:           entry,
+		Size:            attrs.Size,
+		CRC32C:          fmt.Sprintf("%08x", attrs.CRC32C),
+		ContentType:     attrs.ContentType,
+		ContentEncoding: attrs.ContentEncoding,
+	})
+}
+
+func (m *manifest) Write(ctx context.Context, gcs *storage.Client, dst string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sort.Slice(m.entries, func(i, j int) bool {
+		return m.entries[i].Object < m.entries[j].Object
+	})
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range m.entries {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+	return writeLocation(ctx, gcs, dst, b.Bytes(), "application/x-ndjson")
+}
@@ -0,0 +1,167 @@
+package gcsunzip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// resolveDuplicates applies policy to files which have the same name in names.
+// Skipped entries are set to the empty string.
+func resolveDuplicates(e Extractor, names []string, policy string) error {
+	seen := map[string]int{}
+	var dups []string
+	for i, name := range names {
+		if name == "" || e.IsDir(i) {
+			continue
+		}
+		prev, ok := seen[name]
+		if !ok {
+			seen[name] = i
+			continue
+		}
+		switch policy {
+		case "last-wins":
+			names[prev] = ""
+			seen[name] = i
+		case "first-wins":
+			names[i] = ""
+		case "suffix":
+			ext := filepath.Ext(name)
+			stem := strings.TrimSuffix(name, ext)
+			for n := 1; ; n++ {
+				s := stem + "-" + strconv.Itoa(n) + ext
+				if _, ok := seen[s]; !ok {
+					names[i] = s
+					seen[s] = i
+					break
+				}
+			}
+		case "fail":
+			dups = append(dups, e.FileName(i))
+		}
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("%d names: %s", len(dups), strings.Join(dups, ", "))
+	}
+	return nil
+}
+
+// flattenNames moves files in names directly under root by their base names and drops directories.
+// policy is applied to files which have the same base name: fail, suffix (-N) or hash (of the original path).
+func flattenNames(e Extractor, names []string, root, policy string) error {
+	seen := map[string]bool{}
+	var dups []string
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		if e.IsDir(i) {
+			names[i] = ""
+			continue
+		}
+		s := filepath.Join(root, filepath.Base(name))
+		if seen[s] {
+			ext := filepath.Ext(s)
+			stem := strings.TrimSuffix(s, ext)
+			switch policy {
+			case "fail":
+				dups = append(dups, e.FileName(i))
+			case "suffix":
+				for n := 1; seen[s]; n++ {
+					s = stem + "-" + strconv.Itoa(n) + ext
+				}
+			case "hash":
+				h := sha256.Sum256([]byte(filepath.ToSlash(name)))
+				s = stem + "-" + hex.EncodeToString(h[:4]) + ext
+			}
+		}
+		names[i] = s
+		seen[s] = true
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("%d names: %s", len(dups), strings.Join(dups, ", "))
+	}
+	return nil
+}
+
+// sanitizeName rewrites each path component of name so that it is safe as a part of a GCS object name.
+// "." and ".." components are removed, and trailing dots are trimmed.
+func sanitizeName(name, policy string) string {
+	if policy == "none" {
+		return name
+	}
+	sep := string(os.PathSeparator)
+	parts := strings.Split(name, sep)
+	out := parts[:0]
+	for _, p := range parts {
+		p = strings.Map(func(r rune) rune {
+			if !isUnsafeObjectRune(r) {
+				return r
+			}
+			if policy == "strip" {
+				return -1
+			}
+			return '_'
+		}, p)
+		p = strings.TrimRight(p, ".")
+		if p == "" {
+			continue
+		}
+		out = append(out, p)
+	}
+	return strings.Join(out, sep)
+}
+
+func isUnsafeObjectRune(r rune) bool {
+	switch {
+	case r < 0x20, 0x7f <= r && r <= 0x9f:
+		return true
+	}
+	return strings.ContainsRune("#[]*?", r)
+}
+
+// isUnsafePath reports whether name is absolute, has a drive letter or escapes the root with "..".
+func isUnsafePath(name string) bool {
+	if hasDriveLetter(name) || filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) {
+		return true
+	}
+	for _, p := range strings.Split(name, string(os.PathSeparator)) {
+		if p == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// rebasePath makes the unsafe name relative to the root.
+func rebasePath(name string) string {
+	if hasDriveLetter(name) {
+		name = name[2:]
+	}
+	name = strings.TrimLeft(name, `/\`)
+	sep := string(os.PathSeparator)
+	return strings.TrimPrefix(filepath.Clean(sep+name), sep)
+}
+
+func hasDriveLetter(s string) bool {
+	if len(s) < 2 || s[1] != ':' {
+		return false
+	}
+	c := s[0] | 0x20
+	return 'a' <= c && c <= 'z'
+}
+
+// stripComponents drops the first n path components of name like tar --strip-components.
+// It returns "" if nothing is left.
+func stripComponents(name string, n int) string {
+	ps := strings.Split(filepath.Clean(name), string(os.PathSeparator))
+	if len(ps) <= n {
+		return ""
+	}
+	return filepath.Join(ps[n:]...)
+}
@@ -0,0 +1,185 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/pubsub/v1"
+)
+
+const (
+	// notifyTimeout is the timeout of sending a notification, which is sent even if the job is canceled.
+	notifyTimeout = 30 * time.Second
+	// notifyAttempts is the maximum number of attempts to POST to NotifyURL.
+	notifyAttempts = 5
+)
+
+// SignatureHeader is the header of webhook requests which has the HMAC-SHA256 of the body by NotifySecret
+// as sha256=<hex>.
+const SignatureHeader = "X-Gcs-Unzip-Signature"
+
+var topicPattern = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// Summary is the notification of a finished job.
+type Summary struct {
+	Src        string `json:"src"`
+	Dest       string `json:"dest"`
+	ShardIndex int    `json:"shard_index,omitempty"`
+	ShardCount int    `json:"shard_count,omitempty"`
+	// Status is succeeded or failed.
+	Status   string   `json:"status"`
+	Files    int64    `json:"files"`
+	Bytes    int64    `json:"bytes"`
+	Failed   int      `json:"failed"`
+	Skipped  int      `json:"skipped,omitempty"`
+	Renamed  []Rename `json:"renamed,omitempty"`
+	Deleted  int64    `json:"deleted,omitempty"`
+	Duration float64  `json:"duration_seconds"`
+	Error    string   `json:"error,omitempty"`
+	Stats    Stats    `json:"stats"`
+	// Time is when the job finished.
+	Time time.Time `json:"time"`
+}
+
+// NewSummary returns the Summary of a job of cfg which finished with report and err.
+func NewSummary(cfg Config, report Report, err error) Summary {
+	s := Summary{
+		Src:        cfg.Src,
+		Dest:       cfg.Dest,
+		ShardIndex: cfg.ShardIndex,
+		ShardCount: cfg.ShardCount,
+		Status:     "succeeded",
+		Files:      report.Files,
+		Bytes:      report.Bytes,
+		Failed:     report.Failed,
+		Skipped:    report.Skipped,
+		Renamed:    report.Renamed,
+		Deleted:    report.Deleted,
+		Duration:   report.Duration.Seconds(),
+		Stats:      report.Stats,
+		Time:       time.Now(),
+	}
+	if err != nil {
+		s.Status, s.Error = "failed", err.Error()
+	}
+	return s
+}
+
+// notify sends the summary of the job started at start to the destinations of cfg. Failures are only logged.
+func notify(ctx context.Context, cfg Config, start time.Time, report Report, err error) {
+	if cfg.NotifyTopic == "" && cfg.NotifyURL == "" && cfg.RunReport == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), notifyTimeout)
+	defer cancel()
+	s := NewSummary(cfg, report, err)
+	b, err := json.Marshal(s)
+	if err != nil {
+		warnf("failed to marshal the summary: %v", err)
+		return
+	}
+	if cfg.RunReport != "" {
+		if err := writeReport(ctx, cfg, start, s); err != nil {
+			warnf("failed to write report: %v", err)
+		}
+	}
+	if cfg.NotifyTopic != "" {
+		if err := publish(ctx, cfg.NotifyTopic, b, map[string]string{"status": s.Status, "src": s.Src}); err != nil {
+			warnf("failed to publish to %s: %v", cfg.NotifyTopic, err)
+		}
+	}
+	if cfg.NotifyURL != "" {
+		if err := post(ctx, cfg.NotifyURL, cfg.NotifySecret, b); err != nil {
+			warnf("failed to notify %s: %v", cfg.NotifyURL, err)
+		}
+	}
+}
+
+// publish publishes data as a message with attrs.
+func publish(ctx context.Context, topic string, data []byte, attrs map[string]string) error {
+	svc, err := pubsub.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("pubsub: %w", err)
+	}
+	_, err = svc.Projects.Topics.Publish(topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{
+			Data:       base64.StdEncoding.EncodeToString(data),
+			Attributes: attrs,
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	debugf("published to %s", topic)
+	return nil
+}
+
+// post POSTs body as JSON to u, signed by secret if not empty.
+// Network errors, 429 and 5xx are retried with backoff up to notifyAttempts times.
+func post(ctx context.Context, u, secret string, body []byte) error {
+	var signature string
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	bo := gax.Backoff{Initial: time.Second, Max: 10 * time.Second}
+	for attempt := 1; ; attempt++ {
+		err := postOnce(ctx, u, signature, body)
+		if err == nil {
+			debugf("notified %s", u)
+			return nil
+		}
+		var perr *permanentError
+		if errors.As(err, &perr) || attempt == notifyAttempts {
+			return err
+		}
+		debugf("retrying to notify %s: %v", u, err)
+		if err := gax.Sleep(ctx, bo.Pause()); err != nil {
+			return err
+		}
+	}
+}
+
+// permanentError is a response of the webhook which is not worth retrying.
+type permanentError struct{ status string }
+
+func (e *permanentError) Error() string { return e.status }
+
+func postOnce(ctx context.Context, u, signature string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{status: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gcs-unzip")
+	if signature != "" {
+		req.Header.Set(SignatureHeader, signature)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%s", resp.Status)
+	default:
+		return &permanentError{status: resp.Status}
+	}
+}
@@ -0,0 +1,60 @@
+package gcsunzip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// openLocation opens a local file or a gs:// object.
+// It returns os.ErrNotExist if the target does not exist.
+func openLocation(ctx context.Context, gcs *storage.Client, s string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(s, "gs://") {
+		return os.Open(s)
+	}
+	u, err := parseGSURL(s)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gcs.Bucket(u.Hostname()).Object(strings.TrimPrefix(u.Path, "/")).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, os.ErrNotExist
+	}
+	return r, err
+}
+
+// writeLocation replaces a local file or a gs:// object with b.
+func writeLocation(ctx context.Context, gcs *storage.Client, s string, b []byte, contentType string) error {
+	if !strings.HasPrefix(s, "gs://") {
+		tmp, err := os.CreateTemp(filepath.Dir(s), filepath.Base(s)+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(b); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), s)
+	}
+	u, err := parseGSURL(s)
+	if err != nil {
+		return err
+	}
+	o := gcs.Bucket(u.Hostname()).Object(strings.TrimPrefix(u.Path, "/")).Retryer(storage.WithPolicy(storage.RetryAlways))
+	w := o.NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,174 @@
+package gcsunzip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// ShardSpec is the assignment of a shard of an archive to a worker, which is written by Plan.
+type ShardSpec struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+	// Generation pins the archive, so that all shards read the same object even if it is overwritten.
+	Generation int64 `json:"generation,omitempty"`
+	// Entries is the number of entries of the archive, which is checked by the worker.
+	Entries    int `json:"entries"`
+	ShardIndex int `json:"shard_index"`
+	ShardCount int `json:"shard_count"`
+	// Start and End are the range of the entry indices of the shard, whose files are extracted.
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Files int    `json:"files"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// PlanConfig configures Plan.
+type PlanConfig struct {
+	Src  string
+	Dest string
+	// ShardCount is the number of shards.
+	ShardCount int
+	// Specs is a local directory or gs:// prefix to write the spec of each shard as shard-<index>.json if not empty.
+	Specs string
+	// Client is used for GCS requests. A client is created if nil.
+	Client *storage.Client
+	// Encoding is the fallback encoding of entry names which are not valid UTF-8 (default shiftjis).
+	Encoding string
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// EncryptionKey is the AES-256 customer-supplied key of the source.
+	EncryptionKey []byte
+}
+
+// Plan splits the files of an archive into ShardCount contiguous shards of about the same total size,
+// reading only its directory with range requests. Each spec is extracted by Run with Config.Shard,
+// e.g. by workers on other machines, which read only their range of the archive.
+func Plan(ctx context.Context, cfg PlanConfig) ([]ShardSpec, error) {
+	if cfg.Encoding == "" {
+		cfg.Encoding = "shiftjis"
+	}
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	if cfg.ShardCount <= 0 {
+		return nil, fmt.Errorf("%w: invalid ShardCount: %d", ErrUsage, cfg.ShardCount)
+	}
+	nameEncoding, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid Encoding: %w", ErrUsage, err)
+	}
+	if cfg.EncryptionKey != nil && len(cfg.EncryptionKey) != 32 {
+		return nil, fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+	}
+
+	gcs := cfg.Client
+	if gcs == nil && !local {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	ra, size, err := openSource(ctx, gcs, src, cfg.EncryptionKey, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	var generation int64
+	if r, ok := ra.(*remoteReaderAt); ok {
+		generation = r.Generation()
+	}
+	extractor, err := newExtractor(ra, size, src.Path, ExtractorOptions{
+		OldWindows: cfg.OldWindows,
+		Encoding:   nameEncoding,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extractor: %w", err)
+	}
+
+	names := make([]string, extractor.Files())
+	for i := range names {
+		names[i] = extractor.FileName(i)
+	}
+	specs := make([]ShardSpec, cfg.ShardCount)
+	for i := range specs {
+		specs[i] = ShardSpec{
+			Src:        cfg.Src,
+			Dest:       cfg.Dest,
+			Generation: generation,
+			Entries:    len(names),
+			ShardIndex: i,
+			ShardCount: cfg.ShardCount,
+		}
+	}
+	for i, shard := range shardOf(extractor, names, cfg.ShardCount) {
+		if shard < 0 {
+			continue
+		}
+		s := &specs[shard]
+		if s.Files == 0 {
+			s.Start = i
+		}
+		s.End = i + 1
+		s.Files++
+		s.Bytes += extractor.FileSize(i)
+	}
+
+	if cfg.Specs != "" {
+		for _, s := range specs {
+			b, err := json.Marshal(s)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeLocation(ctx, gcs, specPath(cfg.Specs, s.ShardIndex), b, "application/json"); err != nil {
+				return nil, fmt.Errorf("write spec %d: %w", s.ShardIndex, err)
+			}
+		}
+	}
+	return specs, nil
+}
+
+// specPath returns the location of the spec of the shard under the directory or prefix.
+func specPath(dir string, index int) string {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	return fmt.Sprintf("%sshard-%d.json", dir, index)
+}
+
+// LoadShardSpec reads a spec written by Plan from a local file or gs:// object.
+// If s is a directory or prefix ending with /, the spec of the index-th shard under it is read.
+// client is created if nil.
+func LoadShardSpec(ctx context.Context, client *storage.Client, s string, index int) (*ShardSpec, error) {
+	if strings.HasSuffix(s, "/") {
+		s = specPath(s, index)
+	}
+	gcs := client
+	if gcs == nil && strings.HasPrefix(s, "gs://") {
+		var err error
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	r, err := openLocation(ctx, gcs, s)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: spec not found: %s", ErrUsage, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open spec: %w", err)
+	}
+	defer r.Close()
+	var spec ShardSpec
+	if err := json.NewDecoder(r).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("decode spec: %w", err)
+	}
+	return &spec, nil
+}
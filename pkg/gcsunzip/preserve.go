@@ -0,0 +1,53 @@
+package gcsunzip
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// preserveTarget returns the gs:// URL of the object to copy the source archive to by Config.PreserveArchive,
+// which is under the prefix with the base name of the archive if it ends with a slash.
+func preserveTarget(s string, src *url.URL) (*url.URL, error) {
+	u, err := parseGSURL(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		u.Path += path.Base(src.Path)
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+	if u.Hostname() == src.Hostname() && u.Path == src.Path {
+		return nil, fmt.Errorf("same as the source: %s", s)
+	}
+	return u, nil
+}
+
+// copyArchive copies the generation of the source archive to dest by rewriting on the server side,
+// which keeps the content and the metadata of the source. The generation is the latest if 0.
+// The copy with its retries is bounded by timeout if positive.
+func copyArchive(ctx context.Context, gcs *storage.Client, src, dest *url.URL, generation int64, key []byte, kmsKey string, timeout time.Duration) error {
+	so := gcs.Bucket(src.Hostname()).Object(strings.TrimPrefix(src.Path, "/"))
+	if generation != 0 {
+		so = so.Generation(generation)
+	}
+	do := gcs.Bucket(dest.Hostname()).Object(strings.TrimPrefix(dest.Path, "/"))
+	if key != nil {
+		so, do = so.Key(key), do.Key(key)
+	}
+	c := do.Retryer(storage.WithPolicy(storage.RetryAlways)).CopierFrom(so)
+	c.DestinationKMSKeyName = kmsKey
+	ctx, cancel := opContext(ctx, timeout)
+	defer cancel()
+	if _, err := c.Run(ctx); err != nil {
+		return fmt.Errorf("copy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package gcsunzip
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// progress counts the state of uploads, which is reported to Config.Progress and Config.ProgressFunc.
+type progress struct {
+	filesTotal atomic.Int64
+	bytesTotal atomic.Uint64
+
+	files    atomic.Int64
+	bytes    atomic.Int64
+	inFlight atomic.Int64
+	disk     atomic.Int64
+	// settled is the total size in the archive of the entries which are uploaded, skipped or failed,
+	// which is compared with bytesTotal, the total size of the entries.
+	settled atomic.Uint64
+	// stage is the current stage of the job for heartbeats.
+	stage atomic.Value
+}
+
+func (p *progress) setStage(name string) { p.stage.Store(name) }
+
+// completion returns the percent complete and the bytes remaining by the sizes in the archive, or by
+// the number of files if all of them are empty.
+func (p *progress) completion() (float64, uint64) {
+	total, settled := p.bytesTotal.Load(), p.settled.Load()
+	if total == 0 {
+		if files := p.filesTotal.Load(); files > 0 {
+			return min(float64(p.files.Load())/float64(files), 1) * 100, 0
+		}
+		return 0, 0
+	}
+	settled = min(settled, total)
+	return float64(settled) / float64(total) * 100, total - settled
+}
+
+// ProgressRecord is a snapshot of the progress of a job.
+type ProgressRecord struct {
+	Time       time.Time `json:"time"`
+	FilesDone  int64     `json:"files_done"`
+	FilesTotal int       `json:"files_total"`
+	BytesDone  int64     `json:"bytes_done"`
+	BytesTotal uint64    `json:"bytes_total"`
+	// Percent is the percent complete, and BytesRemaining is the size of the entries which are not done yet.
+	// The entries skipped or failed are also done.
+	Percent        float64 `json:"percent"`
+	BytesRemaining uint64  `json:"bytes_remaining"`
+	// Rate is bytes per second since the previous record.
+	Rate      float64 `json:"rate"`
+	InFlight  int64   `json:"in_flight"`
+	DiskInUse int64   `json:"disk_in_use"`
+}
+
+// report calls fn every interval until stop is closed, and the last time after that.
+func (p *progress) report(fn func(ProgressRecord), interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	prevTime, prevBytes := time.Now(), int64(0)
+	write := func() {
+		now := time.Now()
+		bytes := p.bytes.Load()
+		r := ProgressRecord{
+			Time:       now,
+			FilesDone:  p.files.Load(),
+			FilesTotal: int(p.filesTotal.Load()),
+			BytesDone:  bytes,
+			BytesTotal: p.bytesTotal.Load(),
+			InFlight:   p.inFlight.Load(),
+			DiskInUse:  p.disk.Load(),
+		}
+		r.Percent, r.BytesRemaining = p.completion()
+		if d := now.Sub(prevTime).Seconds(); d > 0 {
+			r.Rate = float64(bytes-prevBytes) / d
+		}
+		prevTime, prevBytes = now, bytes
+		fn(r)
+	}
+	for {
+		select {
+		case <-stop:
+			write()
+			return
+		case <-t.C:
+			write()
+		}
+	}
+}
+
+// heartbeat logs the progress every interval until stop is closed.
+func (p *progress) heartbeat(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	start := time.Now()
+	prevTime, prevBytes := start, int64(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			stage, _ := p.stage.Load().(string)
+			files, bytes := p.files.Load(), p.bytes.Load()
+			filesTotal, bytesTotal := p.filesTotal.Load(), p.bytesTotal.Load()
+			percent, remaining := p.completion()
+			var rate float64
+			if d := now.Sub(prevTime).Seconds(); d > 0 {
+				rate = float64(bytes-prevBytes) / d
+			}
+			prevTime, prevBytes = now, bytes
+			elapsed := now.Sub(start).Round(time.Second)
+			logEvent(slog.LevelInfo, []slog.Attr{
+				slog.String("event", "heartbeat"),
+				slog.String("stage", stage),
+				slog.Int64("files_done", files),
+				slog.Int64("files_total", filesTotal),
+				slog.Int64("bytes_done", bytes),
+				slog.Uint64("bytes_total", bytesTotal),
+				slog.Float64("percent", percent),
+				slog.Uint64("bytes_remaining", remaining),
+				slog.Float64("rate", rate),
+				slog.Int64("in_flight", p.inFlight.Load()),
+				slog.Duration("elapsed", elapsed),
+			}, "heartbeat: %s, %.1f%%, %d/%d files, %s/%s, %s left, %s/s, %d in flight, elapsed %s",
+				stage, percent, files, filesTotal, FormatBytes(uint64(bytes)), FormatBytes(bytesTotal), FormatBytes(remaining), FormatBytes(uint64(rate)), p.inFlight.Load(), elapsed)
+		}
+	}
+}
+
+// progressWriter returns a function writing records to w as JSON Lines.
+func progressWriter(w io.Writer) func(ProgressRecord) {
+	enc := json.NewEncoder(w)
+	return func(r ProgressRecord) {
+		if err := enc.Encode(r); err != nil {
+			warnf("failed to write progress: %v", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package gcsunzip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// remoteBlockSize is the size of range requests, which is large enough for reading a central directory sequentially.
+const remoteBlockSize = 1024 * 1024
+
+// remoteReaderAt reads an object with range requests, caching the last block.
+type remoteReaderAt struct {
+	ctx        context.Context
+	o          *storage.ObjectHandle
+	size       int64
+	updated    time.Time
+	generation int64
+	// timeout bounds each range request with its retries, if positive.
+	timeout time.Duration
+	// requests is the number of range requests.
+	requests atomic.Int64
+
+	mu  sync.Mutex
+	off int64
+	buf []byte
+}
+
+// openRemote opens the object, bounding each request with its retries by timeout if positive.
+func openRemote(ctx context.Context, o *storage.ObjectHandle, timeout time.Duration) (*remoteReaderAt, error) {
+	actx, cancel := opContext(ctx, timeout)
+	defer cancel()
+	attrs, err := o.Attrs(actx)
+	if err != nil {
+		return nil, fmt.Errorf("attrs: %w", err)
+	}
+	return &remoteReaderAt{ctx: ctx, o: o.Generation(attrs.Generation), size: attrs.Size, updated: attrs.Updated, generation: attrs.Generation, timeout: timeout}, nil
+}
+
+func (r *remoteReaderAt) Size() int64 {
+	return r.size
+}
+
+// ModTime returns the last modification time of the object.
+func (r *remoteReaderAt) ModTime() time.Time {
+	return r.updated
+}
+
+// Generation returns the generation of the object, which is read even if the object is overwritten.
+func (r *remoteReaderAt) Generation() int64 {
+	return r.generation
+}
+
+// Requests returns the number of range requests so far.
+func (r *remoteReaderAt) Requests() int64 {
+	return r.requests.Load()
+}
+
+func (r *remoteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for len(p) > 0 {
+		if off >= r.size {
+			return n, io.EOF
+		}
+		if off < r.off || r.off+int64(len(r.buf)) <= off {
+			if err := r.fill(off, max(int64(len(p)), remoteBlockSize)); err != nil {
+				return n, err
+			}
+		}
+		c := copy(p, r.buf[off-r.off:])
+		n += c
+		p = p[c:]
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (r *remoteReaderAt) fill(off, length int64) error {
+	length = min(length, r.size-off)
+	r.requests.Add(1)
+	ctx, cancel := opContext(r.ctx, r.timeout)
+	defer cancel()
+	rr, err := r.o.NewRangeReader(ctx, off, length)
+	if err != nil {
+		return fmt.Errorf("range reader: %w", err)
+	}
+	defer rr.Close()
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(rr, buf); err != nil {
+		return fmt.Errorf("read range: %w", err)
+	}
+	r.off, r.buf = off, buf
+	return nil
+}
+
+// opContext returns the context of a GCS request with its retries, which is canceled after timeout if positive.
+func opContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// stallReader cancels the reads of a download if a Read with its retries does not return within timeout,
+// which bounds a stalled download instead of the whole.
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	t := time.AfterFunc(s.timeout, s.cancel)
+	defer t.Stop()
+	return s.r.Read(p)
+}
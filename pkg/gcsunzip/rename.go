@@ -0,0 +1,119 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RenameRule is a sed-like substitution "s/regexp/replacement/flags".
+// The replacement can refer to submatches by \1 to \9 and to the whole match by &.
+// flags are g (replace all matches) and i (case-insensitive).
+type RenameRule struct {
+	src    string
+	re     *regexp.Regexp
+	repl   string
+	global bool
+}
+
+// ParseRenameRule parses a rule such as "s#^data/raw/#bronze/#g".
+func ParseRenameRule(s string) (RenameRule, error) {
+	if len(s) < 2 || s[0] != 's' {
+		return RenameRule{}, fmt.Errorf("must be s/regexp/replacement/")
+	}
+	delim := s[1:2]
+	parts := splitUnescaped(s[2:], delim[0])
+	if len(parts) != 3 {
+		return RenameRule{}, fmt.Errorf("must be s%sregexp%sreplacement%s", delim, delim, delim)
+	}
+	pattern, repl, flags := parts[0], parts[1], parts[2]
+	r := RenameRule{src: s}
+	for _, f := range flags {
+		switch f {
+		case 'g':
+			r.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return RenameRule{}, fmt.Errorf("unknown flag: %c", f)
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return RenameRule{}, fmt.Errorf("compile: %w", err)
+	}
+	r.re = re
+	r.repl = sedReplacement(repl)
+	return r, nil
+}
+
+// splitUnescaped splits s by delim which is not escaped with a backslash, and unescapes the delimiters.
+func splitUnescaped(s string, delim byte) []string {
+	var parts []string
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return append(parts, b.String())
+}
+
+// sedReplacement converts the sed replacement to the template of regexp.Expand.
+func sedReplacement(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '$':
+			b.WriteString("$$")
+		case c == '&':
+			b.WriteString("${0}")
+		case c == '\\' && i+1 < len(s):
+			i++
+			if d := s[i]; '0' <= d && d <= '9' {
+				b.WriteString("${" + string(d) + "}")
+			} else {
+				b.WriteByte(d)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// String returns the rule as it was parsed.
+func (r RenameRule) String() string {
+	return r.src
+}
+
+// Apply rewrites the slash-separated name.
+func (r RenameRule) Apply(name string) string {
+	if r.global {
+		return r.re.ReplaceAllString(name, r.repl)
+	}
+	m := r.re.FindStringSubmatchIndex(name)
+	if m == nil {
+		return name
+	}
+	var b []byte
+	b = append(b, name[:m[0]]...)
+	b = r.re.ExpandString(b, r.repl, name, m)
+	b = append(b, name[m[1]:]...)
+	return string(b)
+}
+
+// applyRenames applies the rules in order to the slash-separated name.
+func applyRenames(rules []RenameRule, name string) string {
+	for _, r := range rules {
+		name = r.Apply(name)
+	}
+	return name
+}
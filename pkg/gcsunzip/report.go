@@ -0,0 +1,60 @@
+package gcsunzip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// reportVars are the variables of Config.RunReport.
+var reportVars = []string{"archive", "date", "ts"}
+
+// reportPath expands the variables of p for the job of src started at start.
+func reportPath(p, src string, start time.Time) (string, error) {
+	s := p
+	for _, v := range reportVars {
+		s = strings.ReplaceAll(s, "{"+v+"}", "")
+	}
+	if strings.ContainsAny(s, "{}") {
+		return "", fmt.Errorf("unknown variable in %s", p)
+	}
+	start = start.UTC()
+	return strings.NewReplacer(
+		"{archive}", trimExt(path.Base(src)),
+		"{date}", start.Format("2006-01-02"),
+		"{ts}", start.Format("20060102T150405Z"),
+	).Replace(p), nil
+}
+
+// writeReport writes the summary as JSON to cfg.RunReport, suffixed with the shard index if sharded.
+func writeReport(ctx context.Context, cfg Config, start time.Time, s Summary) error {
+	p, err := reportPath(cfg.RunReport, cfg.Src, start)
+	if err != nil {
+		return err
+	}
+	if cfg.ShardCount > 1 {
+		p = shardPath(p, cfg.ShardIndex)
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	gcs := cfg.Client
+	if gcs == nil && strings.HasPrefix(p, "gs://") {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	if err := writeLocation(ctx, gcs, p, append(b, '\n'), "application/json"); err != nil {
+		return err
+	}
+	debugf("wrote report to %s", p)
+	return nil
+}
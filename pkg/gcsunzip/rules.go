@@ -0,0 +1,90 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AttrsRule sets object attributes of entries whose names under the archive root match the glob.
+// Later rules override earlier ones.
+type AttrsRule struct {
+	Match              string            `yaml:"match"`
+	ContentType        string            `yaml:"content_type"`
+	CacheControl       string            `yaml:"cache_control"`
+	ContentDisposition string            `yaml:"content_disposition"`
+	ContentLanguage    string            `yaml:"content_language"`
+	Metadata           map[string]string `yaml:"metadata"`
+	StorageClass       string            `yaml:"storage_class"`
+	// Gzip turns the compression on or off regardless of Config.GzipExt and Config.GzipTypes if set.
+	Gzip *bool `yaml:"gzip"`
+}
+
+// LoadAttrsRules reads a YAML list of rules.
+func LoadAttrsRules(name string) ([]AttrsRule, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	var rules []AttrsRule
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if err := validateAttrsRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// validateAttrsRules checks the globs and upper-cases the storage classes of the rules.
+func validateAttrsRules(rules []AttrsRule) error {
+	for i, r := range rules {
+		if r.Match == "" || !validGlob(r.Match) {
+			return fmt.Errorf("rule %d: invalid match: %q", i+1, r.Match)
+		}
+		rules[i].StorageClass = strings.ToUpper(r.StorageClass)
+		switch rules[i].StorageClass {
+		case "", "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE":
+		default:
+			return fmt.Errorf("rule %d: invalid storage_class: %s", i+1, r.StorageClass)
+		}
+	}
+	return nil
+}
+
+// applyAttrsRules merges the rules which match the slash-separated name into one.
+func applyAttrsRules(rules []AttrsRule, name string) AttrsRule {
+	var a AttrsRule
+	for _, r := range rules {
+		if !matchGlob(r.Match, name) {
+			continue
+		}
+		if r.ContentType != "" {
+			a.ContentType = r.ContentType
+		}
+		if r.CacheControl != "" {
+			a.CacheControl = r.CacheControl
+		}
+		if r.ContentDisposition != "" {
+			a.ContentDisposition = r.ContentDisposition
+		}
+		if r.ContentLanguage != "" {
+			a.ContentLanguage = r.ContentLanguage
+		}
+		if r.StorageClass != "" {
+			a.StorageClass = r.StorageClass
+		}
+		if r.Gzip != nil {
+			a.Gzip = r.Gzip
+		}
+		for k, v := range r.Metadata {
+			if a.Metadata == nil {
+				a.Metadata = map[string]string{}
+			}
+			a.Metadata[k] = v
+		}
+	}
+	return a
+}
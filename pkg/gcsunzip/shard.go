@@ -0,0 +1,50 @@
+package gcsunzip
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// shardEntries clears the names of the files which are not assigned to the index-th of count shards.
+// Directories are kept in all shards.
+func shardEntries(e Extractor, names []string, index, count int) {
+	for i, shard := range shardOf(e, names, count) {
+		if shard >= 0 && shard != index {
+			names[i] = ""
+		}
+	}
+}
+
+// shardOf returns the shard of each file of names out of count shards, and -1 for the others.
+// Files are split in the archive order into contiguous runs of about the same total size,
+// so that every shard computes the same partition and reads a compact range of the archive.
+func shardOf(e Extractor, names []string, count int) []int {
+	shards := make([]int, len(names))
+	var files []int
+	var total uint64
+	for i, name := range names {
+		shards[i] = -1
+		if name != "" && !e.IsDir(i) {
+			files = append(files, i)
+			total += e.FileSize(i)
+		}
+	}
+	var offset uint64
+	for n, i := range files {
+		// a file belongs to the shard which its start offset falls into.
+		shard := n * count / len(files)
+		if total > 0 {
+			shard = int(float64(offset) / float64(total) * float64(count))
+		}
+		offset += e.FileSize(i)
+		shards[i] = min(shard, count-1)
+	}
+	return shards
+}
+
+// shardPath inserts the shard index before the extension of p, e.g. manifest.jsonl to manifest.3.jsonl.
+func shardPath(p string, index int) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + "." + strconv.Itoa(index) + ext
+}
@@ -0,0 +1,48 @@
+package gcsunzip
+
+import (
+	"fmt"
+	"strings"
+)
+
+// partsManifestSuffix is the suffix of the object name of an entry split by Config.SplitSize,
+// whose parts are listed in the JSON object of the name with the suffix.
+const partsManifestSuffix = ".parts.json"
+
+// partsManifest lists the parts of an entry split by Config.SplitSize, which are concatenated in order into the entry.
+type partsManifest struct {
+	Entry    string       `json:"entry"`
+	Object   string       `json:"object"`
+	Size     int64        `json:"size"`
+	CRC32C   string       `json:"crc32c"`
+	PartSize int64        `json:"part_size"`
+	Parts    []partObject `json:"parts"`
+}
+
+type partObject struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	CRC32C string `json:"crc32c"`
+}
+
+// partName returns the object name of the i-th part of the object name, e.g. a.csv.part-0001.
+func partName(name string, i int) string {
+	return fmt.Sprintf("%s.part-%04d", name, i)
+}
+
+// partsBase returns the object name of the entry of a part or a parts manifest.
+func partsBase(name string) (string, bool) {
+	if base, ok := strings.CutSuffix(name, partsManifestSuffix); ok {
+		return base, true
+	}
+	i := strings.LastIndex(name, ".part-")
+	if i < 0 || len(name) == i+len(".part-") || strings.Trim(name[i+len(".part-"):], "0123456789") != "" {
+		return "", false
+	}
+	return name[:i], true
+}
+
+// numParts returns the number of the parts of size bytes split by splitSize.
+func numParts(size, splitSize int64) int64 {
+	return (size + splitSize - 1) / splitSize
+}
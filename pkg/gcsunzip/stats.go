@@ -0,0 +1,386 @@
+package gcsunzip
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// Stats are the statistics of the uploads of a job.
+type Stats struct {
+	// Extensions are the number and total size of the uploaded files by extension, where "" is files without one.
+	Extensions map[string]ExtStats `json:"extensions,omitempty"`
+	// InBytes is the total size of the uploaded files, and OutBytes of the stored objects.
+	InBytes  int64 `json:"in_bytes"`
+	OutBytes int64 `json:"out_bytes"`
+	// EncodedInBytes and EncodedOutBytes are the sizes of the files uploaded with Content-Encoding.
+	EncodedInBytes  int64 `json:"encoded_in_bytes"`
+	EncodedOutBytes int64 `json:"encoded_out_bytes"`
+	// UploadP50, UploadP95 and UploadP99 are the percentiles of the upload time of a file in seconds.
+	UploadP50 float64 `json:"upload_p50_seconds"`
+	UploadP95 float64 `json:"upload_p95_seconds"`
+	UploadP99 float64 `json:"upload_p99_seconds"`
+	// Retries is the number of uploads retried after Config.FileTimeout.
+	Retries int64 `json:"retries"`
+	// Slowest are the uploads which took the longest, and Largest are the largest uploaded files,
+	// up to topFiles each in descending order.
+	Slowest []FileStats `json:"slowest,omitempty"`
+	Largest []FileStats `json:"largest,omitempty"`
+	// Stages are the statistics of download, extract, upload and finalize.
+	// extract and upload overlap, because files are uploaded while the others are extracted.
+	Stages map[string]StageStats `json:"stages,omitempty"`
+	// DiskWaitSeconds is the time which extract waited for the disk limit to be released by uploads.
+	DiskWaitSeconds float64 `json:"disk_wait_seconds"`
+	// DiskPeakBytes is the maximum size of the temporary files held at once, and DiskLimitBytes is
+	// the disk limit of the job, which is lowered to the free space of the temporary directory.
+	DiskPeakBytes  int64 `json:"disk_peak_bytes"`
+	DiskLimitBytes int64 `json:"disk_limit_bytes"`
+	// GCS are the Cloud Storage requests to the archive and the extracted objects.
+	GCS GCSStats `json:"gcs"`
+	// Cost is the estimated cost of the uploaded objects, or of the objects to upload by Config.DryRun,
+	// where the sizes are before the compression.
+	Cost Cost `json:"cost"`
+}
+
+// GCSStats are the counts of the Cloud Storage requests of a job, which do not include the manifests,
+// checkpoints and reports.
+type GCSStats struct {
+	// Reads are the downloads and range requests of the archive.
+	Reads int64 `json:"reads"`
+	// Writes are the uploads of objects, including directory placeholders and the success marker.
+	Writes int64 `json:"writes"`
+	// Metadata are the requests of object metadata, e.g. for Config.IfExists.
+	Metadata int64 `json:"metadata"`
+	// Copies are the server-side copies of objects by Config.Dedupe and Config.PreserveArchive.
+	Copies int64 `json:"copies"`
+	// Deletes are the deletions of corrupted objects and the extraneous objects by Config.DeleteExtraneous.
+	Deletes int64 `json:"deletes"`
+	// Retries are the requests and upload chunks retried after transient errors, and Errors are the
+	// transient errors by HTTP status code, or "network" for the others. They are only counted
+	// if Config.Client is nil.
+	Retries int64            `json:"retries"`
+	Errors  map[string]int64 `json:"errors,omitempty"`
+}
+
+// StageStats are the statistics of a stage of a job.
+type StageStats struct {
+	// Seconds is the wall time of the stage.
+	Seconds float64 `json:"seconds"`
+	// Bytes are downloaded, written to the disk, or uploaded in the stage.
+	Bytes int64 `json:"bytes"`
+	// BusySeconds is the time spent on the work, which is summed over the goroutines of upload,
+	// and excludes waiting for the disk limit in extract.
+	BusySeconds float64 `json:"busy_seconds"`
+}
+
+// ExtStats are the statistics of the files of an extension.
+type ExtStats struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// FileStats are the statistics of an uploaded file.
+type FileStats struct {
+	Entry   string  `json:"entry"`
+	Bytes   int64   `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	// Rate is bytes per second of the upload.
+	Rate float64 `json:"rate"`
+}
+
+// topFiles is the number of files in Stats.Slowest and Stats.Largest.
+const topFiles = 10
+
+// pushTop inserts f into top, which is sorted by less in descending order and has up to topFiles.
+func pushTop(top []FileStats, f FileStats, less func(a, b FileStats) bool) []FileStats {
+	i := len(top)
+	for i > 0 && less(top[i-1], f) {
+		i--
+	}
+	if i == topFiles {
+		return top
+	}
+	top = slices.Insert(top, i, f)
+	if len(top) > topFiles {
+		top = top[:topFiles]
+	}
+	return top
+}
+
+// EncodedRatio returns the ratio of the stored size to the original size of the files uploaded with
+// Content-Encoding, or 0 if there are none.
+func (s *Stats) EncodedRatio() float64 {
+	if s.EncodedInBytes == 0 {
+		return 0
+	}
+	return float64(s.EncodedOutBytes) / float64(s.EncodedInBytes)
+}
+
+// statsCollector collects Stats from the uploaders.
+type statsCollector struct {
+	mu        sync.Mutex
+	stats     Stats
+	durations []time.Duration
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{stats: Stats{Extensions: map[string]ExtStats{}, Stages: map[string]StageStats{}, GCS: GCSStats{Errors: map[string]int64{}}}}
+}
+
+func (c *statsCollector) upload(name, entry string, in, out int64, encoded bool, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := FileStats{Entry: entry, Bytes: in, Seconds: d.Seconds()}
+	if f.Seconds > 0 {
+		f.Rate = float64(in) / f.Seconds
+	}
+	c.stats.Slowest = pushTop(c.stats.Slowest, f, func(a, b FileStats) bool { return a.Seconds < b.Seconds })
+	c.stats.Largest = pushTop(c.stats.Largest, f, func(a, b FileStats) bool { return a.Bytes < b.Bytes })
+	ext := fileExt(name)
+	e := c.stats.Extensions[ext]
+	e.Files++
+	e.Bytes += in
+	c.stats.Extensions[ext] = e
+	c.stats.InBytes += in
+	c.stats.OutBytes += out
+	if encoded {
+		c.stats.EncodedInBytes += in
+		c.stats.EncodedOutBytes += out
+	}
+	c.durations = append(c.durations, d)
+	u := c.stats.Stages["upload"]
+	u.Bytes += in
+	u.BusySeconds += d.Seconds()
+	c.stats.Stages["upload"] = u
+}
+
+func (c *statsCollector) retry() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Retries++
+}
+
+// stage records the wall time of the stage since start.
+func (c *statsCollector) stage(name string, start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats.Stages[name]
+	s.Seconds = time.Since(start).Seconds()
+	c.stats.Stages[name] = s
+}
+
+// work records the work of n bytes in d of the stage.
+func (c *statsCollector) work(name string, n int64, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats.Stages[name]
+	s.Bytes += n
+	s.BusySeconds += d.Seconds()
+	c.stats.Stages[name] = s
+}
+
+func (c *statsCollector) disk(wait time.Duration, peak, limit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.DiskWaitSeconds = wait.Seconds()
+	c.stats.DiskPeakBytes = peak
+	c.stats.DiskLimitBytes = limit
+}
+
+// gcsOp counts n requests of op, which is read, write, metadata, copy or delete.
+func (c *statsCollector) gcsOp(op string, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch op {
+	case "read":
+		c.stats.GCS.Reads += n
+	case "write":
+		c.stats.GCS.Writes += n
+	case "metadata":
+		c.stats.GCS.Metadata += n
+	case "copy":
+		c.stats.GCS.Copies += n
+	case "delete":
+		c.stats.GCS.Deletes += n
+	}
+}
+
+// charge adds the cost of ops uploads of n bytes in the storage class.
+func (c *statsCollector) charge(class string, ops, n int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Cost.add(class, ops, n)
+}
+
+func (c *statsCollector) setCost(cost Cost) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Cost = cost
+}
+
+// shouldRetry is storage.ShouldRetry which counts the transient errors.
+func (c *statsCollector) shouldRetry(err error) bool {
+	if !storage.ShouldRetry(err) {
+		return false
+	}
+	code := "network"
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		code = strconv.Itoa(gerr.Code)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.GCS.Retries++
+	c.stats.GCS.Errors[code]++
+	return true
+}
+
+// result returns a copy of the collected Stats.
+func (c *statsCollector) result() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Extensions = maps.Clone(s.Extensions)
+	s.Stages = maps.Clone(s.Stages)
+	s.GCS.Errors = maps.Clone(s.GCS.Errors)
+	s.Slowest = slices.Clone(s.Slowest)
+	s.Largest = slices.Clone(s.Largest)
+	d := slices.Clone(c.durations)
+	slices.Sort(d)
+	s.UploadP50 = percentile(d, 50).Seconds()
+	s.UploadP95 = percentile(d, 95).Seconds()
+	s.UploadP99 = percentile(d, 99).Seconds()
+	return s
+}
+
+// percentile returns the p-th percentile of sorted d by the nearest rank.
+func percentile(d []time.Duration, p int) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	return d[max((len(d)*p+99)/100, 1)-1]
+}
+
+// summaryExtensions is the number of extensions logged by logStats.
+const summaryExtensions = 10
+
+// logStats logs the summary of the stats of a job.
+func logStats(r Report) {
+	s := r.Stats
+	line := fmt.Sprintf("summary: %d files, %s in, %s out", r.Files, FormatBytes(uint64(s.InBytes)), FormatBytes(uint64(s.OutBytes)))
+	if s.EncodedInBytes > 0 {
+		line += fmt.Sprintf(" (encoded %s to %s, %.1f%%)", FormatBytes(uint64(s.EncodedInBytes)), FormatBytes(uint64(s.EncodedOutBytes)), s.EncodedRatio()*100)
+	}
+	line += fmt.Sprintf(", upload p50 %s p95 %s p99 %s, %d retries, wall %s",
+		seconds(s.UploadP50), seconds(s.UploadP95), seconds(s.UploadP99), s.Retries, r.Duration.Round(time.Millisecond))
+	logEvent(slog.LevelInfo, []slog.Attr{
+		slog.String("event", "summary"),
+		slog.Int64("files", r.Files),
+		slog.Int64("in_bytes", s.InBytes),
+		slog.Int64("out_bytes", s.OutBytes),
+		slog.Int64("encoded_in_bytes", s.EncodedInBytes),
+		slog.Int64("encoded_out_bytes", s.EncodedOutBytes),
+		slog.Float64("upload_p50_seconds", s.UploadP50),
+		slog.Float64("upload_p95_seconds", s.UploadP95),
+		slog.Float64("upload_p99_seconds", s.UploadP99),
+		slog.Int64("retries", s.Retries),
+		slog.Float64("disk_wait_seconds", s.DiskWaitSeconds),
+		slog.Int64("disk_peak_bytes", s.DiskPeakBytes),
+		slog.Int64("disk_limit_bytes", s.DiskLimitBytes),
+	}, "%s", line)
+
+	var stages []string
+	for _, name := range []string{"download", "extract", "upload", "finalize"} {
+		st, ok := s.Stages[name]
+		if !ok {
+			continue
+		}
+		line := fmt.Sprintf("%s %s", name, seconds(st.Seconds))
+		if st.Bytes > 0 {
+			line += " " + FormatBytes(uint64(st.Bytes))
+			if st.BusySeconds > 0 {
+				line += fmt.Sprintf(" (%s/s, busy %s)", FormatBytes(uint64(float64(st.Bytes)/st.BusySeconds)), seconds(st.BusySeconds))
+			}
+		}
+		stages = append(stages, line)
+	}
+	if len(stages) > 0 {
+		infof("summary: stages: %s, disk wait %s, disk peak %s of %s", strings.Join(stages, ", "), seconds(s.DiskWaitSeconds),
+			FormatBytes(uint64(s.DiskPeakBytes)), FormatBytes(uint64(s.DiskLimitBytes)))
+	}
+
+	g := s.GCS
+	line = fmt.Sprintf("summary: gcs: %d reads, %d writes, %d metadata, %d copies, %d deletes, %d retries", g.Reads, g.Writes, g.Metadata, g.Copies, g.Deletes, g.Retries)
+	if len(g.Errors) > 0 {
+		codes := make([]string, 0, len(g.Errors))
+		for code := range g.Errors {
+			codes = append(codes, code)
+		}
+		slices.Sort(codes)
+		for i, code := range codes {
+			codes[i] = fmt.Sprintf("%s %d", code, g.Errors[code])
+		}
+		line += fmt.Sprintf(" (%s)", strings.Join(codes, ", "))
+	}
+	infof("%s", line)
+	infof("summary: cost: %d class A ops $%.2f, %s stored $%.2f/month", s.Cost.ClassAOps, s.Cost.ClassA, FormatBytes(uint64(s.Cost.StorageBytes)), s.Cost.Storage)
+
+	exts := make([]string, 0, len(s.Extensions))
+	for ext := range s.Extensions {
+		exts = append(exts, ext)
+	}
+	// the largest extensions first.
+	slices.SortFunc(exts, func(a, b string) int {
+		return cmp.Or(cmp.Compare(s.Extensions[b].Bytes, s.Extensions[a].Bytes), cmp.Compare(a, b))
+	})
+	var parts []string
+	for i, ext := range exts {
+		if i == summaryExtensions {
+			parts = append(parts, fmt.Sprintf("and %d more", len(exts)-i))
+			break
+		}
+		e := s.Extensions[ext]
+		if ext == "" {
+			ext = "(none)"
+		} else {
+			ext = "." + ext
+		}
+		parts = append(parts, fmt.Sprintf("%s %d files %s", ext, e.Files, FormatBytes(uint64(e.Bytes))))
+	}
+	if len(parts) > 0 {
+		infof("summary: extensions: %s", strings.Join(parts, ", "))
+	}
+	logTopFiles(s)
+}
+
+// logTopFiles logs Slowest and Largest of s at debug level.
+func logTopFiles(s Stats) {
+	for _, top := range []struct {
+		name  string
+		files []FileStats
+	}{{"slowest", s.Slowest}, {"largest", s.Largest}} {
+		for i, f := range top.files {
+			logEvent(slog.LevelDebug, []slog.Attr{
+				slog.String("event", top.name),
+				slog.String("entry", f.Entry),
+				slog.Int64("bytes", f.Bytes),
+				slog.Float64("seconds", f.Seconds),
+				slog.Float64("rate", f.Rate),
+			}, "%s %2d: %s(%s): %s, %s/s", top.name, i+1, f.Entry, FormatBytes(uint64(f.Bytes)), seconds(f.Seconds), FormatBytes(uint64(f.Rate)))
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,71 @@
+package gcsunzip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const maxLinkDepth = 8
+
+func isSymlink(e Extractor, i int) bool {
+	return e.Mode(i)&fs.ModeSymlink != 0
+}
+
+// readLinkTarget returns the target of the symlink entry, which is stored as its content.
+func readLinkTarget(e Extractor, i int) (string, error) {
+	rc, err := e.Open(i)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// linkTarget implements Extractor.LinkTarget with readLinkTarget.
+func linkTarget(e Extractor, i int) string {
+	if !isSymlink(e, i) {
+		return ""
+	}
+	target, err := readLinkTarget(e, i)
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// resolveLink follows the symlink entry within the archive and returns the index of the regular file it points to.
+// files maps entry names to their indexes.
+func resolveLink(e Extractor, files map[string]int, i int) (int, error) {
+	for depth := 0; depth < maxLinkDepth; depth++ {
+		if !isSymlink(e, i) {
+			return i, nil
+		}
+		target, err := readLinkTarget(e, i)
+		if err != nil {
+			return 0, fmt.Errorf("read link: %w", err)
+		}
+		target = filepath.FromSlash(target)
+		if filepath.IsAbs(target) {
+			return 0, fmt.Errorf("absolute link target: %s", target)
+		}
+		p := filepath.Join(filepath.Dir(e.FileName(i)), target)
+		if p == ".." || strings.HasPrefix(p, ".."+string(os.PathSeparator)) {
+			return 0, fmt.Errorf("link target outside the archive: %s", target)
+		}
+		j, ok := files[p]
+		if !ok {
+			return 0, fmt.Errorf("link target not found: %s", target)
+		}
+		i = j
+	}
+	return 0, errors.New("too many levels of symbolic links")
+}
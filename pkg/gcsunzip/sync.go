@@ -0,0 +1,107 @@
+package gcsunzip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
+)
+
+// listObjects returns the attributes of the objects under prefix by name, for Config.Sync.
+func listObjects(ctx context.Context, bucket *storage.BucketHandle, prefix string) (map[string]*storage.ObjectAttrs, error) {
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Bucket", "Generation", "Size", "CRC32C", "MD5", "ContentType", "ContentEncoding", "Updated", "Metadata"}); err != nil {
+		return nil, fmt.Errorf("attr selection: %w", err)
+	}
+	objects := map[string]*storage.ObjectAttrs{}
+	it := bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+		objects[attrs.Name] = attrs
+	}
+}
+
+// unchangedSince reports whether the object is not older than the entry modified at mtime with the declared size.
+// compressed objects are only compared by the time because their stored sizes differ from the entries.
+func unchangedSince(attrs *storage.ObjectAttrs, mtime time.Time, size uint64, sizeKnown bool) bool {
+	if mtime.IsZero() || attrs.Updated.Before(mtime) {
+		return false
+	}
+	if attrs.ContentEncoding != "" {
+		return true
+	}
+	return sizeKnown && attrs.Size == int64(size)
+}
+
+// entryChecksum returns the CRC-32 of the entry in hex, or empty if the archive does not record it.
+func entryChecksum(e Extractor, i int) string {
+	crc, ok := entryCRC32(e, i)
+	if !ok || !e.SizeKnown(i) {
+		return ""
+	}
+	return fmt.Sprintf("%08x", crc)
+}
+
+// setEntryChecksum records the CRC-32 and the size of the entry in the metadata of its object as
+// entry-crc32 and entry-size, with which SyncBy crc32 compares the entry in later runs without extracting it.
+func setEntryChecksum(a *storage.ObjectAttrs, checksum string, size uint64) {
+	if checksum == "" {
+		return
+	}
+	if a.Metadata == nil {
+		a.Metadata = map[string]string{}
+	}
+	a.Metadata["entry-crc32"] = checksum
+	a.Metadata["entry-size"] = strconv.FormatUint(size, 10)
+}
+
+// unchangedEntry reports whether the object was uploaded from an entry of the CRC-32 and the size.
+// The stored CRC32C cannot be compared with the CRC-32 of the archive, and it differs for compressed objects.
+func unchangedEntry(attrs *storage.ObjectAttrs, checksum string, size uint64) bool {
+	return checksum != "" && attrs.Metadata["entry-crc32"] == checksum && attrs.Metadata["entry-size"] == strconv.FormatUint(size, 10)
+}
+
+// deleteExtraneous deletes the objects which are not kept, and returns the number of deleted objects.
+// The listed generations are deleted, so objects written after listing are kept.
+func deleteExtraneous(ctx context.Context, bucket *storage.BucketHandle, objects map[string]*storage.ObjectAttrs, keep map[string]bool, concurrency int, st *statsCollector) (int64, error) {
+	var names []string
+	for name := range objects {
+		if !keep[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var deleted atomic.Int64
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	for _, name := range names {
+		eg.Go(func() error {
+			st.gcsOp("delete", 1)
+			err := bucket.Object(name).Generation(objects[name].Generation).Delete(ctx)
+			switch {
+			case errors.Is(err, storage.ErrObjectNotExist):
+				return nil
+			case err != nil:
+				return fmt.Errorf("delete %s: %w", name, err)
+			}
+			deleted.Add(1)
+			debugf("delete extraneous: gs://%s/%s", bucket.BucketName(), name)
+			return nil
+		})
+	}
+	err := eg.Wait()
+	return deleted.Load(), err
+}
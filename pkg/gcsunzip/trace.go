@@ -0,0 +1,19 @@
+package gcsunzip
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records the spans of jobs with the global TracerProvider, which discards them unless it is set.
+var tracer = otel.Tracer("github.com/orisano/gcs-unzip/pkg/gcsunzip")
+
+// endSpan ends span with the status of err.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
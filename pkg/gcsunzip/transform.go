@@ -0,0 +1,83 @@
+package gcsunzip
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// transformStderrLimit is the size of the stderr of transform commands kept for errors.
+const transformStderrLimit = 4096
+
+// transformFile replaces the file p by the stdout of the shell command cmdline, and returns its size and CRC-32C.
+// "{src}" in cmdline is replaced by the quoted path of the file, which is piped to stdin otherwise.
+// The entry name is passed as GCS_UNZIP_ENTRY.
+func transformFile(ctx context.Context, cmdline, p, entry string) (int64, uint32, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", strings.ReplaceAll(cmdline, "{src}", shellQuote(p)))
+	cmd.Env = append(os.Environ(), "GCS_UNZIP_ENTRY="+entry)
+	if !strings.Contains(cmdline, "{src}") {
+		in, err := os.Open(p)
+		if err != nil {
+			return 0, 0, fmt.Errorf("open: %w", err)
+		}
+		defer in.Close()
+		cmd.Stdin = in
+	}
+
+	// a unique name does not truncate another extracted file, whose name can be the one with a suffix.
+	out, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".transform-*")
+	if err != nil {
+		return 0, 0, fmt.Errorf("create: %w", err)
+	}
+	tmp := out.Name()
+	defer os.Remove(tmp)
+	defer out.Close()
+	h := crc32.New(crc32cTable)
+	var n writeCounter
+	cmd.Stdout = io.MultiWriter(out, h, &n)
+	stderr := &limitedBuffer{limit: transformStderrLimit}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return 0, 0, fmt.Errorf("run: %w: %s", err, msg)
+		}
+		return 0, 0, fmt.Errorf("run: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return 0, 0, fmt.Errorf("close: %w", err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return 0, 0, fmt.Errorf("rename: %w", err)
+	}
+	return int64(n), h.Sum32(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+type writeCounter int64
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	*c += writeCounter(len(p))
+	return len(p), nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := b.limit - b.Len(); rest > 0 {
+		b.Buffer.Write(p[:min(len(p), rest)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,292 @@
+package gcsunzip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
+)
+
+type expectedObject struct {
+	size int64
+	crc  uint32
+	gzip bool
+}
+
+func verifyObjects(ctx context.Context, bucket *storage.BucketHandle, prefix string, expected map[string]expectedObject) error {
+	q := &storage.Query{Prefix: prefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "CRC32C", "ContentEncoding", "CustomerKeySHA256"}); err != nil {
+		return fmt.Errorf("attr selection: %w", err)
+	}
+	seen := make(map[string]bool, len(expected))
+	mismatched := 0
+	it := bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+		e, ok := expected[attrs.Name]
+		if !ok {
+			continue
+		}
+		seen[attrs.Name] = true
+		// gzip objects are stored compressed, so neither size nor checksum
+		// can be compared with the archive entry.
+		if e.gzip || attrs.ContentEncoding != "" {
+			continue
+		}
+		// listing does not return checksums of objects encrypted with customer-supplied keys.
+		if attrs.CustomerKeySHA256 != "" && attrs.CRC32C == 0 {
+			if attrs.Size != e.size {
+				warnf("verify: size mismatch: %s: got %d, want %d", attrs.Name, attrs.Size, e.size)
+				mismatched++
+			}
+			continue
+		}
+		if attrs.Size != e.size {
+			warnf("verify: size mismatch: %s: got %d, want %d", attrs.Name, attrs.Size, e.size)
+			mismatched++
+		} else if attrs.CRC32C != e.crc {
+			warnf("verify: crc32c mismatch: %s: got %08x, want %08x", attrs.Name, attrs.CRC32C, e.crc)
+			mismatched++
+		}
+	}
+
+	var missing []string
+	for name := range expected {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		warnf("verify: missing: %s", name)
+	}
+	if len(missing) > 0 || mismatched > 0 {
+		return fmt.Errorf("%d missing, %d mismatched of %d objects", len(missing), mismatched, len(expected))
+	}
+	return nil
+}
+
+// VerifyConfig configures Verify.
+type VerifyConfig struct {
+	// Src is the gs:// URL of the archive.
+	Src string
+	// Dest is the gs:// URL of the destination which the archive was extracted to.
+	Dest string
+	// Client is used for GCS requests. A client is created if nil.
+	Client *storage.Client
+	// Concurrency is the number of goroutines for reading objects with Checksum (default 24).
+	Concurrency int
+	// Checksum reads objects to compare their CRC-32 with the archive.
+	Checksum bool
+	// GzipExt is the file extensions which were gzipped, whose sizes are not compared.
+	GzipExt []string
+	// WithMeta expects metadata entries such as __MACOSX and .DS_Store.
+	WithMeta bool
+	// StripComponents is the number of the leading path components dropped from entry names.
+	StripComponents int
+	// NoArchivePrefix expects entries directly under the destination prefix instead of <prefix>/<archive>/.
+	NoArchivePrefix bool
+	// Encoding is the fallback encoding of entry names which are not valid UTF-8 (default shiftjis).
+	Encoding string
+	// OldWindows treats backslashes as path separators in all zip entry names.
+	OldWindows bool
+	// EncryptionKey is the AES-256 customer-supplied key of the source and the objects.
+	EncryptionKey []byte
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	// Entries is the number of entries expected under the destination.
+	Entries int
+	// Matched is the number of objects whose names and sizes match the entries.
+	Matched int
+	// Differences is the number of extra, missing and mismatched objects.
+	Differences int64
+}
+
+// Verify compares the directory of an archive with objects already extracted under the destination.
+// It returns ErrVerify if there are differences, which are logged as warnings.
+func Verify(ctx context.Context, cfg VerifyConfig) (VerifyReport, error) {
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 24
+	}
+	if cfg.Encoding == "" {
+		cfg.Encoding = "shiftjis"
+	}
+	var report VerifyReport
+	src, err := parseGSURL(cfg.Src)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse src: %w", ErrUsage, err)
+	}
+	dest, err := parseGSURL(cfg.Dest)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse dest: %w", ErrUsage, err)
+	}
+	archiveName := trimExt(path.Base(src.Path))
+	dt, err := newDestTemplate(strings.TrimPrefix(dest.Path, "/"), archiveName, time.Now(), !cfg.NoArchivePrefix)
+	if err != nil {
+		return report, fmt.Errorf("%w: parse dest: %w", ErrUsage, err)
+	}
+	nameEncoding, err := lookupEncoding(cfg.Encoding)
+	if err != nil {
+		return report, fmt.Errorf("%w: invalid Encoding: %w", ErrUsage, err)
+	}
+	csek := cfg.EncryptionKey
+	if csek != nil && len(csek) != 32 {
+		return report, fmt.Errorf("%w: invalid EncryptionKey: must be 32 bytes", ErrUsage)
+	}
+	useGzip := map[string]bool{}
+	for _, ext := range cfg.GzipExt {
+		useGzip["."+strings.ToLower(ext)] = true
+	}
+
+	gcs := cfg.Client
+	if gcs == nil {
+		gcs, err = storage.NewClient(ctx)
+		if err != nil {
+			return report, fmt.Errorf("storage client: %w", err)
+		}
+		defer gcs.Close()
+	}
+	ra, size, err := openSource(ctx, gcs, src, csek, 0, 0)
+	if err != nil {
+		return report, err
+	}
+	extractor, err := newExtractor(ra, size, src.Path, ExtractorOptions{
+		OldWindows: cfg.OldWindows,
+		Encoding:   nameEncoding,
+	})
+	if err != nil {
+		return report, fmt.Errorf("extractor: %w", err)
+	}
+
+	type entry struct {
+		index int
+		gzip  bool
+	}
+	expected := map[string]entry{}
+	for i := 0; i < extractor.Files(); i++ {
+		name := extractor.FileName(i)
+		if extractor.IsDir(i) || !cfg.WithMeta && isIgnoreMeta(name) {
+			continue
+		}
+		if isUnsafePath(name) {
+			name = rebasePath(name)
+		}
+		if cfg.StripComponents > 0 {
+			name = stripComponents(name, cfg.StripComponents)
+		}
+		if name == "" {
+			continue
+		}
+		expected[dt.Object(filepath.ToSlash(name))] = entry{index: i, gzip: useGzip[strings.ToLower(filepath.Ext(name))]}
+	}
+
+	bucket := gcs.Bucket(dest.Hostname())
+	q := &storage.Query{Prefix: dt.Prefix()}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "ContentEncoding"}); err != nil {
+		return report, fmt.Errorf("attr selection: %w", err)
+	}
+	var drift atomic.Int64
+	seen := make(map[string]bool, len(expected))
+	var matched []string
+	encodings := map[string]string{}
+	it := bucket.Objects(ctx, q)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("list objects: %w", err)
+		}
+		e, ok := expected[attrs.Name]
+		if !ok {
+			warnf("verify: extra: %s", attrs.Name)
+			drift.Add(1)
+			continue
+		}
+		seen[attrs.Name] = true
+		encodings[attrs.Name] = attrs.ContentEncoding
+		if want := extractor.FileSize(e.index); !e.gzip && attrs.ContentEncoding == "" && uint64(attrs.Size) != want {
+			warnf("verify: size mismatch: %s: got %d, want %d", attrs.Name, attrs.Size, want)
+			drift.Add(1)
+			continue
+		}
+		matched = append(matched, attrs.Name)
+	}
+	var missing []string
+	for name := range expected {
+		if !seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		warnf("verify: missing: %s", name)
+	}
+	drift.Add(int64(len(missing)))
+
+	if cfg.Checksum {
+		eg, ctx := errgroup.WithContext(ctx)
+		eg.SetLimit(cfg.Concurrency)
+		for _, name := range matched {
+			want, ok := entryCRC32(extractor, expected[name].index)
+			if !ok {
+				continue
+			}
+			eg.Go(func() error {
+				o := bucket.Object(name)
+				if csek != nil {
+					o = o.Key(csek)
+				}
+				r, err := o.NewReader(ctx)
+				if err != nil {
+					return fmt.Errorf("reader(%s): %w", name, err)
+				}
+				defer r.Close()
+				dr, err := newDecoder(encodings[name], r)
+				if err != nil {
+					return fmt.Errorf("decoder(%s): %w", name, err)
+				}
+				defer dr.Close()
+				h := crc32.NewIEEE()
+				if _, err := io.Copy(h, dr); err != nil {
+					return fmt.Errorf("read(%s): %w", name, err)
+				}
+				if got := h.Sum32(); got != want {
+					warnf("verify: crc32 mismatch: %s: got %08x, want %08x", name, got, want)
+					drift.Add(1)
+				}
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return report, err
+		}
+	}
+
+	report = VerifyReport{Entries: len(expected), Matched: len(matched), Differences: drift.Load()}
+	infof("verify: %d entries, %d objects matched, %d differences", report.Entries, report.Matched, report.Differences)
+	if report.Differences > 0 {
+		return report, fmt.Errorf("%w: %d differences", ErrVerify, report.Differences)
+	}
+	return report, nil
+}
@@ -0,0 +1,4 @@
+// Package jobpb is the gRPC API of gcs-unzip serve -grpc-addr, which is defined in job.proto.
+package jobpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative job.proto
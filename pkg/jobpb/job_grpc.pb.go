@@ -0,0 +1,239 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: job.proto
+
+package jobpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	JobControl_Submit_FullMethodName         = "/gcsunzip.v1.JobControl/Submit"
+	JobControl_GetStatus_FullMethodName      = "/gcsunzip.v1.JobControl/GetStatus"
+	JobControl_Cancel_FullMethodName         = "/gcsunzip.v1.JobControl/Cancel"
+	JobControl_StreamProgress_FullMethodName = "/gcsunzip.v1.JobControl/StreamProgress"
+)
+
+// JobControlClient is the client API for JobControl service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type JobControlClient interface {
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*Job, error)
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Job, error)
+	Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Job, error)
+	StreamProgress(ctx context.Context, in *StreamProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Job], error)
+}
+
+type jobControlClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewJobControlClient(cc grpc.ClientConnInterface) JobControlClient {
+	return &jobControlClient{cc}
+}
+
+func (c *jobControlClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, JobControl_Submit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobControlClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, JobControl_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobControlClient) Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, JobControl_Cancel_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *jobControlClient) StreamProgress(ctx context.Context, in *StreamProgressRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Job], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &JobControl_ServiceDesc.Streams[0], JobControl_StreamProgress_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamProgressRequest, Job]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type JobControl_StreamProgressClient = grpc.ServerStreamingClient[Job]
+
+// JobControlServer is the server API for JobControl service.
+// All implementations must embed UnimplementedJobControlServer
+// for forward compatibility.
+type JobControlServer interface {
+	Submit(context.Context, *SubmitRequest) (*Job, error)
+	GetStatus(context.Context, *GetStatusRequest) (*Job, error)
+	Cancel(context.Context, *CancelRequest) (*Job, error)
+	StreamProgress(*StreamProgressRequest, grpc.ServerStreamingServer[Job]) error
+	mustEmbedUnimplementedJobControlServer()
+}
+
+// UnimplementedJobControlServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedJobControlServer struct{}
+
+func (UnimplementedJobControlServer) Submit(context.Context, *SubmitRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedJobControlServer) GetStatus(context.Context, *GetStatusRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedJobControlServer) Cancel(context.Context, *CancelRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedJobControlServer) StreamProgress(*StreamProgressRequest, grpc.ServerStreamingServer[Job]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamProgress not implemented")
+}
+func (UnimplementedJobControlServer) mustEmbedUnimplementedJobControlServer() {}
+func (UnimplementedJobControlServer) testEmbeddedByValue()                    {}
+
+// UnsafeJobControlServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to JobControlServer will
+// result in compilation errors.
+type UnsafeJobControlServer interface {
+	mustEmbedUnimplementedJobControlServer()
+}
+
+func RegisterJobControlServer(s grpc.ServiceRegistrar, srv JobControlServer) {
+	// If the following call pancis, it indicates UnimplementedJobControlServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&JobControl_ServiceDesc, srv)
+}
+
+func _JobControl_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobControlServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: JobControl_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobControlServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobControl_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobControlServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: JobControl_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobControlServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobControl_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(JobControlServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: JobControl_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(JobControlServer).Cancel(ctx, req.(*CancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _JobControl_StreamProgress_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamProgressRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(JobControlServer).StreamProgress(m, &grpc.GenericServerStream[StreamProgressRequest, Job]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type JobControl_StreamProgressServer = grpc.ServerStreamingServer[Job]
+
+// JobControl_ServiceDesc is the grpc.ServiceDesc for JobControl service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var JobControl_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gcsunzip.v1.JobControl",
+	HandlerType: (*JobControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _JobControl_Submit_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _JobControl_GetStatus_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _JobControl_Cancel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamProgress",
+			Handler:       _JobControl_StreamProgress_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "job.proto",
+}
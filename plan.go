@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runPlan splits an archive into shards and writes their specs as the coordinator of distributed extraction.
+// Each spec is extracted by a worker with -shard-spec, reading only its range of the archive.
+func runPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip plan <src> <dest> <specs>:\n")
+		fs.PrintDefaults()
+	}
+	shards := fs.Int("shards", 0, "number of shards (required)")
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the source")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	if *shards <= 0 {
+		return fmt.Errorf("%w: invalid -shards: %d", gcsunzip.ErrUsage, *shards)
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	specs, err := gcsunzip.Plan(context.Background(), gcsunzip.PlanConfig{
+		Src:           fs.Arg(0),
+		Dest:          fs.Arg(1),
+		ShardCount:    *shards,
+		Specs:         fs.Arg(2),
+		Encoding:      *encodingName,
+		OldWindows:    *oldWindows,
+		EncryptionKey: csek,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tENTRIES\tFILES\tSIZE")
+	for _, s := range specs {
+		fmt.Fprintf(w, "%d\t%d-%d\t%d\t%s\n", s.ShardIndex, s.Start, s.End, s.Files, gcsunzip.FormatBytes(s.Bytes))
+	}
+	return w.Flush()
+}
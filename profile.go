@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime/trace"
+)
+
+// startProfiling serves net/http/pprof on pprofAddr and writes an execution trace to traceFile if not empty.
+// The returned function stops them.
+func startProfiling(pprofAddr, traceFile string) (func(), error) {
+	var stops []func()
+	stop := func() {
+		for _, f := range stops {
+			f()
+		}
+	}
+	if pprofAddr != "" {
+		lis, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return nil, fmt.Errorf("listen pprof: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		srv := &http.Server{Handler: mux}
+		go func() {
+			if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error(fmt.Sprintf("pprof: %v", err))
+			}
+		}()
+		slog.Info(fmt.Sprintf("listening on %s for pprof", lis.Addr()))
+		stops = append(stops, func() { srv.Close() })
+	}
+	if traceFile != "" {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("create trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return nil, fmt.Errorf("start trace: %w", err)
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			if err := f.Close(); err != nil {
+				slog.Warn(fmt.Sprintf("failed to write trace: %v", err))
+			}
+		})
+	}
+	return stop, nil
+}
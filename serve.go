@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+	"github.com/orisano/gcs-unzip/pkg/jobpb"
+)
+
+// extractRequest is the body of POST /extract, which is also the payload of tasks created by dispatch.
+type extractRequest struct {
+	Src        string `json:"src"`
+	Dest       string `json:"dest"`
+	ShardIndex int    `json:"shard_index,omitempty"`
+	ShardCount int    `json:"shard_count,omitempty"`
+}
+
+// extractResponse is the result of POST /extract.
+type extractResponse struct {
+	Files    int64   `json:"files"`
+	Bytes    int64   `json:"bytes"`
+	Failed   int     `json:"failed"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// runServe runs an HTTP server which extracts an archive, or a shard of it, for each request.
+// It is the extraction endpoint of the tasks created by dispatch, e.g. on Cloud Run, and also runs
+// asynchronous jobs by /jobs and the JobControl gRPC service. /healthz and /readyz are for probes,
+// and /readyz fails while draining after SIGTERM.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip serve:\n")
+		fs.PrintDefaults()
+	}
+	logs := defineLogFlags(fs)
+	extractConfig := cliflag.DefineExtract(fs)
+	addr := fs.String("addr", "", "address to listen on (default :$PORT, or :8080)")
+	grpcAddr := fs.String("grpc-addr", "", "address to listen on for the JobControl gRPC service (disabled if empty)")
+	jobState := fs.String("job-state", "", "gs://bucket/prefix/ to save the state of each job as <id>.json")
+	memoryLimit := cliflag.Bytes(fs, "memory-limit", 0, "memory for the chunks of uploads shared by the jobs (0 means unlimited)")
+	maxJobs := fs.Int("max-jobs", 1, "number of asynchronous jobs running at once, and the others are pending")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "time to wait for in-flight requests and jobs after SIGINT or SIGTERM")
+	drainDelay := fs.Duration("drain-delay", 0, "time to keep listening with /readyz failing after SIGINT or SIGTERM, e.g. until the load balancer stops routing")
+	otelEndpoint := fs.String("otel-endpoint", "", "OTLP/gRPC endpoint to export traces of the requests and jobs to (e.g. http://localhost:4317)")
+	pprofAddr := fs.String("pprof-addr", "", "address to serve net/http/pprof on, e.g. localhost:6060 (disabled if empty)")
+	traceFile := fs.String("trace", "", "write a runtime execution trace to this file, for go tool trace")
+	fs.String("config", "", "YAML file of flags, which are overridden by the command line")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	if err := logs.setup(); err != nil {
+		return err
+	}
+	base, err := extractConfig()
+	if err != nil {
+		return err
+	}
+	// -disk-limit and -n are shared by the jobs running at once.
+	base.Budget = gcsunzip.NewBudget(base.DiskLimit, *memoryLimit, base.Concurrency)
+	if *addr == "" {
+		*addr = ":8080"
+		if port := os.Getenv("PORT"); port != "" {
+			*addr = ":" + port
+		}
+	}
+
+	stopProfiling, err := startProfiling(*pprofAddr, *traceFile)
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+	shutdownTracing, err := setupTracing(context.Background(), *otelEndpoint)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Warn(fmt.Sprintf("failed to export traces: %v", err))
+		}
+	}()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	var store *jobStore
+	if *jobState != "" {
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return fmt.Errorf("storage: %w", err)
+		}
+		defer client.Close()
+		store, err = newJobStore(client, *jobState)
+		if err != nil {
+			return fmt.Errorf("%w: invalid -job-state: %w", gcsunzip.ErrUsage, err)
+		}
+	}
+	jobs := newJobManager(ctx, base, store, *maxJobs)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if jobs.draining() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("POST /extract", func(w http.ResponseWriter, r *http.Request) {
+		if jobs.draining() {
+			writeJSON(w, http.StatusServiceUnavailable, extractResponse{Error: "draining"})
+			return
+		}
+		serveExtract(w, r, jobs)
+	})
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		if jobs.draining() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		var req extractRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("decode: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Src == "" || req.Dest == "" {
+			http.Error(w, "src and dest are required", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, jobs.submit(req))
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		st, err := jobs.status(r.Context(), r.PathValue("id"))
+		if errors.Is(err, errJobNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		st, ok := jobs.cancelJob(r.Context(), r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	})
+	srv := &http.Server{
+		Addr:        *addr,
+		Handler:     otelhttp.NewHandler(mux, "serve"),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	var gsrv *grpc.Server
+	hsrv := health.NewServer()
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return fmt.Errorf("listen grpc: %w", err)
+		}
+		gsrv = grpc.NewServer()
+		jobpb.RegisterJobControlServer(gsrv, &jobControlServer{jobs: jobs})
+		healthpb.RegisterHealthServer(gsrv, hsrv)
+		go func() {
+			if err := gsrv.Serve(lis); err != nil {
+				slog.Error(fmt.Sprintf("grpc: %v", err))
+			}
+		}()
+		slog.Info(fmt.Sprintf("listening on %s for gRPC", *grpcAddr))
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	done := make(chan error, 1)
+	go func() {
+		sig := <-sigCh
+		signal.Stop(sigCh)
+		// new requests are refused while draining, and in-flight ones continue.
+		jobs.drain()
+		hsrv.Shutdown()
+		if *drainDelay > 0 {
+			slog.Warn(fmt.Sprintf("%v received, draining for %s", sig, *drainDelay))
+			time.Sleep(*drainDelay)
+		}
+		slog.Warn(fmt.Sprintf("%v received, waiting up to %s for in-flight requests and jobs", sig, *shutdownTimeout))
+		sctx, scancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer scancel()
+		err := srv.Shutdown(sctx)
+		jobs.wait(sctx)
+		// requests and jobs which are still running are interrupted, and the tasks of the requests are retried.
+		cancel(gcsunzip.ErrInterrupted)
+		if gsrv != nil {
+			gsrv.Stop()
+		}
+		done <- err
+	}()
+
+	slog.Info(fmt.Sprintf("listening on %s", *addr))
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if err := <-done; err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
+
+// serveExtract runs a job of the request synchronously. It responds 400 for invalid requests,
+// which are not worth retrying, and 500 for the other failures.
+// The ID of the job is the task name of Cloud Tasks if the request is a task.
+func serveExtract(w http.ResponseWriter, r *http.Request, jobs *jobManager) {
+	var req extractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, extractResponse{Error: fmt.Sprintf("decode: %v", err)})
+		return
+	}
+	id := r.Header.Get("X-CloudTasks-TaskName")
+	if id == "" {
+		id = newJobID()
+	}
+	report, err := jobs.extract(r.Context(), id, req)
+	res := extractResponse{
+		Files:    report.Files,
+		Bytes:    report.Bytes,
+		Failed:   report.Failed,
+		Duration: report.Duration.Seconds(),
+	}
+	code := http.StatusOK
+	if err != nil {
+		res.Error = err.Error()
+		code = http.StatusInternalServerError
+		if errors.Is(err, gcsunzip.ErrUsage) {
+			code = http.StatusBadRequest
+		}
+		slog.LogAttrs(r.Context(), slog.LevelError, fmt.Sprintf("extract %s: %v", req.Src, err),
+			slog.String("event", "error"),
+			slog.String("src", req.Src),
+			slog.Int("shard_index", req.ShardIndex),
+			slog.String("error", err.Error()),
+		)
+	}
+	writeJSON(w, code, res)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
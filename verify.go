@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/orisano/gcs-unzip/internal/cliflag"
+	"github.com/orisano/gcs-unzip/pkg/gcsunzip"
+)
+
+// runVerify compares the directory of an archive with objects already extracted under the destination.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of gcs-unzip verify <src> <dest>:\n")
+		fs.PrintDefaults()
+	}
+	n := fs.Int("n", 24, "number of goroutines for reading objects with -checksum")
+	checksum := fs.Bool("checksum", false, "read objects to compare their CRC-32 with the archive")
+	gzipExt := fs.String("gzip-ext", "", "comma-separated list of file extensions which were gzipped, whose sizes are not compared")
+	withMeta := fs.Bool("with-meta", false, "")
+	strip := fs.Int("strip-components", 0, "drop the first N path components of entry names, skipping entries which have no more")
+	noArchivePrefix := fs.Bool("no-archive-prefix", false, "entries are directly under the destination prefix instead of <prefix>/<archive>/")
+	encodingName := fs.String("encoding", "shiftjis", "fallback encoding of entry names which are not valid UTF-8 (e.g. cp437, cp866, gbk, euckr, shiftjis or auto)")
+	oldWindows := fs.Bool("old-windows", false, "treat backslashes as path separators in all zip entry names (detected per entry by default)")
+	encryptionKey := fs.String("encryption-key-base64", "", "base64-encoded AES-256 customer-supplied key of the source and the objects")
+	fs.Parse(args)
+	if err := cliflag.Load(fs); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("%w: invalid args", gcsunzip.ErrUsage)
+	}
+	csek, err := cliflag.DecodeKey(*encryptionKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = gcsunzip.Verify(context.Background(), gcsunzip.VerifyConfig{
+		Src:             fs.Arg(0),
+		Dest:            fs.Arg(1),
+		Concurrency:     *n,
+		Checksum:        *checksum,
+		GzipExt:         cliflag.SplitList(*gzipExt),
+		WithMeta:        *withMeta,
+		StripComponents: *strip,
+		NoArchivePrefix: *noArchivePrefix,
+		Encoding:        *encodingName,
+		OldWindows:      *oldWindows,
+		EncryptionKey:   csek,
+	})
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// set by goreleaser with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// versionDeps are the modules whose versions are printed by -version.
+var versionDeps = []string{
+	"github.com/klauspost/compress",
+	"github.com/bodgit/sevenzip",
+	"cloud.google.com/go/storage",
+}
+
+func printVersion(w io.Writer) {
+	v, c, d := version, commit, date
+	deps := map[string]string{}
+	goVersion := ""
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		goVersion = bi.GoVersion
+		if v == "" {
+			v = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+		for _, m := range bi.Deps {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+			deps[m.Path] = m.Version
+		}
+	}
+	fmt.Fprintf(w, "gcs-unzip %s\n", orUnknown(v))
+	fmt.Fprintf(w, "commit: %s\n", orUnknown(c))
+	fmt.Fprintf(w, "built: %s\n", orUnknown(d))
+	fmt.Fprintf(w, "go: %s\n", orUnknown(goVersion))
+	for _, p := range versionDeps {
+		fmt.Fprintf(w, "%s: %s\n", p, orUnknown(deps[p]))
+	}
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}